@@ -23,6 +23,9 @@ var (
 // Config defines gRPC credential configuration.
 type Config struct {
 	TLSConfig *tls.Config
+	// GetClientCertificate is an optional callback to provide the client certificate,
+	// it allows to use the reloaded certificate for new connections
+	GetClientCertificate func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
 }
 
 // Bundle defines gRPC credential interface.
@@ -35,8 +38,17 @@ type Bundle interface {
 
 // NewBundle constructs a new gRPC credential bundle.
 func NewBundle(cfg Config) Bundle {
+	tlsCfg := cfg.TLSConfig
+	if cfg.GetClientCertificate != nil {
+		if tlsCfg != nil {
+			tlsCfg = tlsCfg.Clone()
+		} else {
+			tlsCfg = &tls.Config{}
+		}
+		tlsCfg.GetClientCertificate = cfg.GetClientCertificate
+	}
 	return &bundle{
-		tc: newTransportCredential(cfg.TLSConfig),
+		tc: newTransportCredential(tlsCfg),
 		rc: newPerRPCCredential(),
 	}
 }
@@ -0,0 +1,63 @@
+package roles
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+
+	"github.com/effective-security/porto/xhttp/identity"
+	"github.com/pkg/errors"
+)
+
+// APIKeyTokenType defines token type for API key identities
+const APIKeyTokenType = "APIKey"
+
+type apiKeyEntry struct {
+	hash [sha256.Size]byte
+	id   APIKeyIdentity
+}
+
+func newAPIKeyEntries(keys map[string]APIKeyIdentity) []apiKeyEntry {
+	entries := make([]apiKeyEntry, 0, len(keys))
+	for key, id := range keys {
+		if key == "" {
+			continue
+		}
+		entries = append(entries, apiKeyEntry{
+			hash: sha256.Sum256([]byte(key)),
+			id:   id,
+		})
+	}
+	return entries
+}
+
+// apiKeyIdentity returns identity for API key.
+// The keys are compared by hash in constant time,
+// and all entries are checked regardless of a match
+func (p *provider) apiKeyIdentity(key string) (identity.Identity, error) {
+	if key == "" {
+		return nil, errors.Errorf("api key not provided")
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	var found *apiKeyEntry
+	for i := range p.apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], p.apiKeys[i].hash[:]) == 1 {
+			found = &p.apiKeys[i]
+		}
+	}
+	if found == nil {
+		return nil, errors.Errorf("invalid api key")
+	}
+
+	if err := p.checkSubject(found.id.Subject); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{
+		"sub": found.id.Subject,
+	}
+	if found.id.Tenant != "" {
+		claims["tenant"] = found.id.Tenant
+	}
+	return identity.NewIdentity(found.id.Role, found.id.Subject, found.id.Tenant, claims, "", APIKeyTokenType), nil
+}
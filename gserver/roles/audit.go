@@ -0,0 +1,59 @@
+package roles
+
+import (
+	"context"
+
+	"github.com/effective-security/porto/xhttp/identity"
+)
+
+// Authentication methods reported in AuditEvent
+const (
+	AuthMethodDPoP   = "DPoP"
+	AuthMethodJWT    = "JWT"
+	AuthMethodBasic  = "Basic"
+	AuthMethodAPIKey = "APIKey"
+	AuthMethodTLS    = "TLS"
+	AuthMethodGuest  = "Guest"
+)
+
+// AuditEvent describes the authentication decision
+type AuditEvent struct {
+	// Method is the authentication method that produced the identity,
+	// or the last attempted method if the authentication failed in StrictMode
+	Method string
+	// Subject of the identity
+	Subject string
+	// Role of the identity
+	Role string
+	// Tenant of the identity
+	Tenant string
+	// Success is true if the caller is authenticated,
+	// it's false for the guest identity and the failures
+	Success bool
+	// Reason describes the last failed verification of the credentials,
+	// including the ones that fell back to the guest identity
+	Reason string
+}
+
+// AuditSink receives the authentication decisions
+// of IdentityFromRequest and IdentityFromContext
+type AuditSink interface {
+	// Audit is called for each authentication decision
+	Audit(ctx context.Context, event *AuditEvent)
+}
+
+// audit reports the authentication decision to the sink, if provided
+func (p *provider) audit(ctx context.Context, event *AuditEvent, id identity.Identity, err error) {
+	if p.opts.auditSink == nil {
+		return
+	}
+	if err != nil {
+		event.Reason = err.Error()
+	} else {
+		event.Subject = id.Subject()
+		event.Role = id.Role()
+		event.Tenant = id.Tenant()
+		event.Success = event.Method != AuthMethodGuest
+	}
+	p.opts.auditSink.Audit(ctx, event)
+}
@@ -0,0 +1,108 @@
+package roles
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/effective-security/porto/xhttp/identity"
+	"github.com/effective-security/xlog"
+	"github.com/pkg/errors"
+)
+
+// AnyRole allows access for any role, including guest
+const AnyRole = "*"
+
+// AuthorizerConfig provides access rules for Authorizer
+type AuthorizerConfig struct {
+	// Rules is a map of method to the allowed roles.
+	// For gRPC the method is the full method name, like `/pkg.Service/Method`,
+	// for HTTP the method is in `GET /v1/path` format, see HTTPMethod.
+	// The method ending with `*` matches any method with the prefix,
+	// the longest matching prefix is used.
+	// The `*` role allows access for any role
+	Rules map[string][]string `json:"rules" yaml:"rules"`
+	// DefaultRoles specifies the roles allowed for the methods
+	// not found in Rules, by default the access is denied
+	DefaultRoles []string `json:"default_roles" yaml:"default_roles"`
+}
+
+// Authorizer provides role based access control for the methods
+type Authorizer struct {
+	exact    map[string]map[string]bool
+	prefixes []string
+	prefix   map[string]map[string]bool
+	def      map[string]bool
+}
+
+// NewAuthorizer returns Authorizer
+func NewAuthorizer(cfg *AuthorizerConfig) *Authorizer {
+	a := &Authorizer{
+		exact:  make(map[string]map[string]bool),
+		prefix: make(map[string]map[string]bool),
+		def:    roleSet(cfg.DefaultRoles),
+	}
+	for method, roles := range cfg.Rules {
+		if strings.HasSuffix(method, "*") {
+			p := strings.TrimSuffix(method, "*")
+			a.prefix[p] = roleSet(roles)
+			a.prefixes = append(a.prefixes, p)
+		} else {
+			a.exact[method] = roleSet(roles)
+		}
+	}
+	// longest prefix first
+	sort.Slice(a.prefixes, func(i, j int) bool {
+		return len(a.prefixes[i]) > len(a.prefixes[j])
+	})
+	return a
+}
+
+// Authorize returns ErrPermissionDenied,
+// if none of the identity's roles is allowed to access the method
+func (a *Authorizer) Authorize(id identity.Identity, method string) error {
+	allowed := a.allowedRoles(method)
+	if allowed[AnyRole] {
+		return nil
+	}
+	role := ""
+	if id != nil {
+		role = id.Role()
+		// any of identity's roles is allowed
+		for _, r := range identity.Roles(id) {
+			if r != "" && allowed[r] {
+				return nil
+			}
+		}
+	}
+
+	logger.KV(xlog.DEBUG, "reason", "denied", "role", role, "method", method)
+	return errors.WithMessagef(ErrPermissionDenied, "role %q is not allowed for %s", role, method)
+}
+
+// allowedRoles returns the roles allowed for the method
+func (a *Authorizer) allowedRoles(method string) map[string]bool {
+	if roles, ok := a.exact[method]; ok {
+		return roles
+	}
+	for _, p := range a.prefixes {
+		if strings.HasPrefix(method, p) {
+			return a.prefix[p]
+		}
+	}
+	return a.def
+}
+
+// HTTPMethod returns the method of HTTP request for Authorizer,
+// in `GET /v1/path` format
+func HTTPMethod(r *http.Request) string {
+	return r.Method + " " + r.URL.Path
+}
+
+func roleSet(roles []string) map[string]bool {
+	m := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		m[r] = true
+	}
+	return m
+}
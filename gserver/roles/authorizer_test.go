@@ -0,0 +1,83 @@
+package roles_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/effective-security/porto/gserver/roles"
+	"github.com/effective-security/porto/xhttp/identity"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizer(t *testing.T) {
+	a := roles.NewAuthorizer(&roles.AuthorizerConfig{
+		Rules: map[string][]string{
+			"/pkg.Service/Get":    {"reader", "admin"},
+			"/pkg.Service/*":      {"admin"},
+			"/pkg.Status/*":       {roles.AnyRole},
+			"GET /v1/status":      {roles.AnyRole},
+			"POST /v1/users":      {"admin"},
+			"GET /v1/users/*":     {"reader"},
+			"GET /v1/users/admin": {"admin"},
+		},
+	})
+
+	admin := identity.NewIdentity("admin", "a", "", nil, "", "")
+	reader := identity.NewIdentity("reader", "r", "", nil, "", "")
+	guest := identity.NewIdentity(identity.GuestRoleName, "", "", nil, "", "")
+	support := identity.NewIdentityWithRoles([]string{"support", "reader"}, "s", "", nil, "", "", nil)
+
+	tcases := []struct {
+		id     identity.Identity
+		method string
+		err    string
+	}{
+		{admin, "/pkg.Service/Get", ""},
+		{reader, "/pkg.Service/Get", ""},
+		{admin, "/pkg.Service/Delete", ""},
+		{reader, "/pkg.Service/Delete", `role "reader" is not allowed for /pkg.Service/Delete: permission denied`},
+		{guest, "/pkg.Status/Version", ""},
+		{nil, "/pkg.Status/Version", ""},
+		{guest, "GET /v1/status", ""},
+		{reader, "GET /v1/users/123", ""},
+		{reader, "GET /v1/users/admin", `role "reader" is not allowed for GET /v1/users/admin: permission denied`},
+		{admin, "POST /v1/users", ""},
+		{nil, "POST /v1/users", `role "" is not allowed for POST /v1/users: permission denied`},
+		// any of the roles is allowed
+		{support, "GET /v1/users/123", ""},
+		{support, "POST /v1/users", `role "support" is not allowed for POST /v1/users: permission denied`},
+		// default deny
+		{admin, "/other.Service/Get", `role "admin" is not allowed for /other.Service/Get: permission denied`},
+	}
+	for _, tc := range tcases {
+		err := a.Authorize(tc.id, tc.method)
+		if tc.err == "" {
+			assert.NoError(t, err, tc.method)
+		} else {
+			assert.EqualError(t, err, tc.err)
+			assert.True(t, errors.Is(err, roles.ErrPermissionDenied))
+		}
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/v1/status?debug=true", nil)
+	assert.Equal(t, "GET /v1/status", roles.HTTPMethod(r))
+	assert.NoError(t, a.Authorize(guest, roles.HTTPMethod(r)))
+}
+
+func TestAuthorizerDefaultRoles(t *testing.T) {
+	a := roles.NewAuthorizer(&roles.AuthorizerConfig{
+		Rules: map[string][]string{
+			"/pkg.Admin/*": {"admin"},
+		},
+		DefaultRoles: []string{"reader", "admin"},
+	})
+
+	admin := identity.NewIdentity("admin", "a", "", nil, "", "")
+	reader := identity.NewIdentity("reader", "r", "", nil, "", "")
+
+	assert.NoError(t, a.Authorize(reader, "/pkg.Service/Get"))
+	assert.NoError(t, a.Authorize(admin, "/pkg.Service/Get"))
+	assert.NoError(t, a.Authorize(admin, "/pkg.Admin/Delete"))
+	assert.Error(t, a.Authorize(reader, "/pkg.Admin/Delete"))
+}
@@ -0,0 +1,55 @@
+package roles
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/effective-security/porto/xhttp/identity"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicTokenType defines token type for HTTP Basic authentication
+const BasicTokenType = "Basic"
+
+// dummyBasicHash is compared for the unknown users,
+// to prevent the users enumeration by the response time
+const dummyBasicHash = "$2a$10$i.DxP5jqLbSXecxHfdi7Nucq45yQ5T5htM1LnvGzb7LN/HieiBMMS"
+
+// parseBasicAuth parses base64 encoded `user:password` credentials
+func parseBasicAuth(token string) (user, password string, ok bool) {
+	c, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return
+	}
+	user, password, ok = strings.Cut(string(c), ":")
+	return
+}
+
+// basicIdentity returns identity for HTTP Basic credentials
+func (p *provider) basicIdentity(token string) (identity.Identity, error) {
+	user, password, ok := parseBasicAuth(token)
+	if !ok || user == "" {
+		return nil, errors.Errorf("invalid basic credentials")
+	}
+
+	hash, found := p.config.Basic.Users[user]
+	if !found {
+		hash = dummyBasicHash
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil || !found {
+		// the same error for unknown user and invalid password
+		return nil, errors.Errorf("invalid basic credentials")
+	}
+
+	if err := p.checkSubject(user); err != nil {
+		return nil, err
+	}
+
+	roles := defaultRoles(p.basicRoles[user], p.config.Basic.DefaultAuthenticatedRole)
+	claims := map[string]interface{}{
+		"sub": user,
+	}
+	return identity.NewIdentityWithRoles(roles, user, "", claims, "", BasicTokenType, nil), nil
+}
@@ -0,0 +1,112 @@
+package roles
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/effective-security/porto/xhttp/identity"
+)
+
+type identityCacheEntry struct {
+	key       string
+	id        identity.Identity
+	expiresAt time.Time
+}
+
+// identityCache provides LRU cache of the verified identities,
+// the entries are evicted when the token expires
+type identityCache struct {
+	size int
+	now  func() time.Time
+
+	lock  sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func newIdentityCache(size int) *identityCache {
+	return &identityCache{
+		size:  size,
+		now:   time.Now,
+		ll:    list.New(),
+		items: make(map[string]*list.Element, size),
+	}
+}
+
+// identityCacheKey returns the cache key for the token
+func identityCacheKey(token, tokenType string) string {
+	h := sha256.Sum256([]byte(tokenType + " " + token))
+	return hex.EncodeToString(h[:])
+}
+
+// Get returns the cached identity, or nil if not found or expired
+func (c *identityCache) Get(key string) identity.Identity {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*identityCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(el)
+		return nil
+	}
+	c.ll.MoveToFront(el)
+	return entry.id
+}
+
+// Add adds the identity to the cache until expiresAt
+func (c *identityCache) Add(key string, id identity.Identity, expiresAt time.Time) {
+	now := c.now()
+	if !now.Before(expiresAt) {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*identityCacheEntry)
+		entry.id = id
+		entry.expiresAt = expiresAt
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	if c.ll.Len() >= c.size {
+		// evict the expired entries first
+		for el := c.ll.Back(); el != nil; {
+			prev := el.Prev()
+			if !now.Before(el.Value.(*identityCacheEntry).expiresAt) {
+				c.remove(el)
+			}
+			el = prev
+		}
+	}
+	for c.ll.Len() >= c.size {
+		c.remove(c.ll.Back())
+	}
+
+	c.items[key] = c.ll.PushFront(&identityCacheEntry{
+		key:       key,
+		id:        id,
+		expiresAt: expiresAt,
+	})
+}
+
+// Len returns the number of entries in the cache
+func (c *identityCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+func (c *identityCache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*identityCacheEntry).key)
+}
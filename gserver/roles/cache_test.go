@@ -0,0 +1,52 @@
+package roles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/effective-security/porto/xhttp/identity"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_identityCache(t *testing.T) {
+	now := time.Now()
+	c := newIdentityCache(2)
+	c.now = func() time.Time { return now }
+
+	id1 := identity.NewIdentity("r1", "s1", "", nil, "", "")
+	id2 := identity.NewIdentity("r2", "s2", "", nil, "", "")
+	id3 := identity.NewIdentity("r3", "s3", "", nil, "", "")
+
+	// expired tokens are not cached
+	c.Add("k0", id1, now)
+	assert.Equal(t, 0, c.Len())
+
+	c.Add("k1", id1, now.Add(time.Minute))
+	c.Add("k2", id2, now.Add(time.Hour))
+	assert.Equal(t, id1, c.Get("k1"))
+	assert.Equal(t, id2, c.Get("k2"))
+	assert.Nil(t, c.Get("k3"))
+
+	// LRU is evicted
+	assert.Equal(t, id1, c.Get("k1"))
+	c.Add("k3", id3, now.Add(time.Hour))
+	assert.Equal(t, 2, c.Len())
+	assert.Nil(t, c.Get("k2"))
+	assert.Equal(t, id1, c.Get("k1"))
+	assert.Equal(t, id3, c.Get("k3"))
+
+	// expired entry is evicted, even if recently used
+	assert.Equal(t, id3, c.Get("k3"))
+	assert.Equal(t, id1, c.Get("k1"))
+	now = now.Add(2 * time.Minute)
+	c.Add("k2", id2, now.Add(time.Hour))
+	assert.Equal(t, 2, c.Len())
+	assert.Nil(t, c.Get("k1"))
+	assert.Equal(t, id2, c.Get("k2"))
+	assert.Equal(t, id3, c.Get("k3"))
+
+	// expired on get
+	now = now.Add(2 * time.Hour)
+	assert.Nil(t, c.Get("k2"))
+	assert.Equal(t, 1, c.Len())
+}
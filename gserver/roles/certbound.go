@@ -0,0 +1,68 @@
+package roles
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+
+	"github.com/effective-security/xpki/jwt"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// CnfX5tS256 specifies the confirmation method for certificate-bound tokens,
+// see RFC 8705
+const CnfX5tS256 = "x5t#S256"
+
+// certThumbprint returns base64url encoded SHA-256 thumbprint of the certificate
+func certThumbprint(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+	h := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// peerCertificate returns the client certificate of TLS connection, or nil
+func peerCertificate(state *tls.ConnectionState) *x509.Certificate {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// peerCertificateFromContext returns the client certificate of gRPC peer, or nil
+func peerCertificateFromContext(ctx context.Context) *x509.Certificate {
+	c, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	si, ok := c.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return peerCertificate(&si.State)
+}
+
+// verifyCertBinding checks the `cnf` x5t#S256 claim against
+// the thumbprint of the client certificate
+func verifyCertBinding(claims jwt.MapClaims, thumbprint string) error {
+	if thumbprint == "" {
+		return errors.Errorf("client certificate is required for certificate-bound token")
+	}
+	cnf, ok := claimValue(claims, "cnf").(map[string]interface{})
+	if !ok {
+		return errors.Errorf("cnf claim not found")
+	}
+	x5t, _ := cnf[CnfX5tS256].(string)
+	if x5t == "" {
+		return errors.Errorf("cnf claim missing %s", CnfX5tS256)
+	}
+	if x5t != thumbprint {
+		return errors.Errorf("certificate thumbprint mismatch")
+	}
+	return nil
+}
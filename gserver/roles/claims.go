@@ -0,0 +1,273 @@
+package roles
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/effective-security/porto/x/slices"
+	"github.com/effective-security/xpki/jwt"
+	"github.com/pkg/errors"
+)
+
+// claimValue returns the value of the claim by dot-delimited path,
+// for example `realm_access.roles`, or nil if a node in the path is missing.
+// A top-level claim with the exact name takes precedence over the path.
+func claimValue(claims jwt.MapClaims, path string) interface{} {
+	if v, ok := claims[path]; ok {
+		return v
+	}
+
+	var cur interface{} = map[string]interface{}(claims)
+	for _, name := range strings.Split(path, ".") {
+		var m map[string]interface{}
+		switch tv := cur.(type) {
+		case map[string]interface{}:
+			m = tv
+		case jwt.MapClaims:
+			m = tv
+		default:
+			return nil
+		}
+		v, ok := m[name]
+		if !ok {
+			return nil
+		}
+		cur = v
+	}
+	return cur
+}
+
+// claimStrings returns the values of the claim by dot-delimited path,
+// the claim can be a single value or an array
+func claimStrings(claims jwt.MapClaims, path string) []string {
+	switch tv := claimValue(claims, path).(type) {
+	case nil, map[string]interface{}, jwt.MapClaims:
+		return nil
+	case string:
+		return []string{tv}
+	case []string:
+		return tv
+	case []interface{}:
+		var res []string
+		for _, item := range tv {
+			if s := toString(item); s != "" {
+				res = append(res, s)
+			}
+		}
+		return res
+	default:
+		if s := toString(tv); s != "" {
+			return []string{s}
+		}
+	}
+	return nil
+}
+
+// toString co-oerces the claim value to a string
+func toString(v interface{}) string {
+	return jwt.MapClaims{"v": v}.String("v")
+}
+
+// claimString returns the value of the claim by dot-delimited path,
+// if the claim is an array, then the first value is returned
+func claimString(claims jwt.MapClaims, path string) string {
+	vals := claimStrings(claims, path)
+	if len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// claimTenant returns the tenant from the claim,
+// or extracted from the subject by the pattern if the claim is not present
+func claimTenant(claims jwt.MapClaims, path, subject string, pattern *regexp.Regexp) string {
+	if tenant := claimString(claims, path); tenant != "" || pattern == nil {
+		return tenant
+	}
+	match := pattern.FindStringSubmatch(subject)
+	if len(match) < 2 {
+		return ""
+	}
+	if idx := pattern.SubexpIndex("tenant"); idx > 0 {
+		return match[idx]
+	}
+	return match[1]
+}
+
+// validateClaims validates the claims as jwt.MapClaims.Valid,
+// with the skew leeway for the time based claims.
+// The leeway for `iat` and `nbf` is not less than jwt.DefaultTimeSkew
+func validateClaims(claims jwt.MapClaims, cfg jwt.VerifyConfig, skew time.Duration) error {
+	if err := validateTimeClaims(claims, jwt.TimeNowFn(), skew); err != nil {
+		return err
+	}
+	if err := claims.VerifyIssuer(cfg.ExpectedIssuer); err != nil {
+		return err
+	}
+	if err := claims.VerifySubject(cfg.ExpectedSubject); err != nil {
+		return err
+	}
+	return claims.VerifyAudience(cfg.ExpectedAudience)
+}
+
+// validateTimeClaims validates `exp`, `iat` and `nbf` claims with the skew leeway
+func validateTimeClaims(claims jwt.MapClaims, now time.Time, skew time.Duration) error {
+	if err := claims.VerifyExpiresAt(now.Add(-skew), false); err != nil {
+		return err
+	}
+	if skew < jwt.DefaultTimeSkew {
+		skew = jwt.DefaultTimeSkew
+	}
+	if err := claims.VerifyIssuedAt(now.Add(skew), false); err != nil {
+		return err
+	}
+	return claims.VerifyNotBefore(now.Add(skew), false)
+}
+
+// publicKeyProvider is implemented by jwt.Provider
+type publicKeyProvider interface {
+	PublicKey() crypto.PublicKey
+}
+
+// parseToken returns the claims of the token verified by the parser.
+// If the parser provides the public key, then the signature is verified
+// with the key, and the claims are validated with the skew leeway,
+// otherwise the parser validates the claims by its own rules
+func parseToken(parser jwt.Parser, token string, cfg jwt.VerifyConfig, skew time.Duration) (jwt.MapClaims, error) {
+	var key crypto.PublicKey
+	if pk, ok := parser.(publicKeyProvider); ok {
+		key = pk.PublicKey()
+	}
+	alg, _ := tokenAlg(token)
+	if key == nil || skew == 0 || strings.HasPrefix(alg, "HS") {
+		return parser.ParseToken(token, cfg)
+	}
+
+	tp := jwt.TokenParser{
+		UseJSONNumber:        true,
+		SkipClaimsValidation: true,
+	}
+	claims := jwt.MapClaims{}
+	t, err := tp.ParseWithClaims(token, cfg, claims, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to verify token")
+	}
+	if !t.Valid {
+		return nil, errors.Errorf("invalid token")
+	}
+	if err = validateClaims(claims, cfg, skew); err != nil {
+		return nil, errors.WithMessage(err, "failed to verify token")
+	}
+	return claims, nil
+}
+
+// findRoles returns the roles mapped to the claim values.
+// If the claim is an array, the values are checked in the order
+// they appear in the claim, the roles of the first match are returned first.
+func findRoles(roles map[string][]string, values []string) []string {
+	var res []string
+	for _, v := range values {
+		for _, role := range roles[v] {
+			if !slices.ContainsString(res, role) {
+				res = append(res, role)
+			}
+		}
+	}
+	return res
+}
+
+// mapRoles returns the map of identity to the roles,
+// from the map of role to identities.
+// The roles of identity are sorted by name,
+// the identities are normalized if specified
+func mapRoles(roles map[string][]string, normalize bool) map[string][]string {
+	names := make([]string, 0, len(roles))
+	for role := range roles {
+		names = append(names, role)
+	}
+	sort.Strings(names)
+
+	res := make(map[string][]string)
+	for _, role := range names {
+		for _, user := range roles[role] {
+			if normalize {
+				user = normalizeValue(user)
+			}
+			res[user] = append(res[user], role)
+		}
+	}
+	return res
+}
+
+// normalizeValue returns lowercased and trimmed value
+func normalizeValue(v string) string {
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// defaultRoles returns the roles,
+// or the default role if the roles are empty
+func defaultRoles(roles []string, role string) []string {
+	if len(roles) == 0 && role != "" {
+		return []string{role}
+	}
+	return roles
+}
+
+// claimScopes returns the list of scopes from the claim,
+// the value can be a space separated string or an array
+func claimScopes(claims jwt.MapClaims, path string) []string {
+	if path == "" {
+		return nil
+	}
+	var scopes []string
+	for _, v := range claimStrings(claims, path) {
+		scopes = append(scopes, strings.Fields(v)...)
+	}
+	return scopes
+}
+
+// tokenAlg returns the `alg` header of JWS compact serialized token
+func tokenAlg(token string) (string, error) {
+	i := strings.IndexByte(token, '.')
+	if i <= 0 {
+		return "", errors.Errorf("invalid token format")
+	}
+	js, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(token[:i], "="))
+	if err != nil {
+		return "", errors.Errorf("invalid token header")
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+	}
+	if err = json.Unmarshal(js, &hdr); err != nil {
+		return "", errors.Errorf("invalid token header")
+	}
+	return hdr.Alg, nil
+}
+
+// verifyAlg checks the token signing algorithm against the allowed list.
+// If the list is empty, then only `none` is rejected,
+// and the token format is checked by jwt.Parser
+func verifyAlg(m *JWTIdentityMap, token string) error {
+	alg, err := tokenAlg(token)
+	if err != nil {
+		if len(m.AllowedAlgs) == 0 {
+			return nil
+		}
+		return err
+	}
+	if alg == "" || strings.EqualFold(alg, "none") {
+		return errors.Errorf("alg not allowed: %q", alg)
+	}
+	if len(m.AllowedAlgs) > 0 && !slices.ContainsString(m.AllowedAlgs, alg) {
+		return errors.Errorf("alg not allowed: %q", alg)
+	}
+	return nil
+}
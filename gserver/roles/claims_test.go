@@ -0,0 +1,154 @@
+package roles
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/effective-security/xpki/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_claimStrings(t *testing.T) {
+	claims := jwt.MapClaims{
+		"sub":                "12234",
+		"https://tenant.org": "t1",
+		"num":                json.Number("123"),
+		"groups":             []interface{}{"g1", "g2"},
+		"realm_access": map[string]interface{}{
+			"roles": []interface{}{"admin", "user"},
+		},
+		"resource_access": jwt.MapClaims{
+			"account": map[string]interface{}{
+				"role": "manager",
+			},
+		},
+	}
+
+	tcases := []struct {
+		path string
+		exp  []string
+	}{
+		{"sub", []string{"12234"}},
+		{"https://tenant.org", []string{"t1"}},
+		{"num", []string{"123"}},
+		{"groups", []string{"g1", "g2"}},
+		{"realm_access.roles", []string{"admin", "user"}},
+		{"resource_access.account.role", []string{"manager"}},
+		{"realm_access", nil},
+		{"realm_access.missing", nil},
+		{"missing.roles", nil},
+		{"sub.missing", nil},
+		{"", nil},
+	}
+	for _, tc := range tcases {
+		assert.Equal(t, tc.exp, claimStrings(claims, tc.path), "failed for: %s", tc.path)
+	}
+
+	assert.Equal(t, "admin", claimString(claims, "realm_access.roles"))
+	assert.Equal(t, "", claimString(claims, "missing.roles"))
+}
+
+func Test_findRoles(t *testing.T) {
+	roles := mapRoles(map[string][]string{
+		"admin":   {"g1"},
+		"user":    {"g2", "g1"},
+		"support": {"g2"},
+	}, false)
+	assert.Equal(t, []string{"admin", "user"}, roles["g1"])
+	assert.Equal(t, []string{"support", "user"}, roles["g2"])
+
+	assert.Empty(t, findRoles(roles, nil))
+	assert.Empty(t, findRoles(roles, []string{"g3"}))
+	assert.Equal(t, []string{"support", "user", "admin"}, findRoles(roles, []string{"g3", "g2", "g1"}))
+	assert.Equal(t, []string{"admin", "user", "support"}, findRoles(roles, []string{"g1", "g2"}))
+}
+
+func Test_mapRolesNormalized(t *testing.T) {
+	roles := mapRoles(map[string][]string{
+		"admin": {" Denis@Trusty.ca "},
+	}, true)
+	assert.Equal(t, []string{"admin"}, roles["denis@trusty.ca"])
+	assert.Empty(t, roles[" Denis@Trusty.ca "])
+}
+
+func Test_defaultRoles(t *testing.T) {
+	assert.Empty(t, defaultRoles(nil, ""))
+	assert.Equal(t, []string{"def"}, defaultRoles(nil, "def"))
+	assert.Equal(t, []string{"admin"}, defaultRoles([]string{"admin"}, "def"))
+}
+
+func Test_claimScopes(t *testing.T) {
+	claims := jwt.MapClaims{
+		"scope": "read write  admin",
+		"scp":   []interface{}{"read", "write"},
+	}
+	assert.Nil(t, claimScopes(claims, ""))
+	assert.Nil(t, claimScopes(claims, "missing"))
+	assert.Equal(t, []string{"read", "write", "admin"}, claimScopes(claims, "scope"))
+	assert.Equal(t, []string{"read", "write"}, claimScopes(claims, "scp"))
+}
+
+func Test_claimTenant(t *testing.T) {
+	claims := jwt.MapClaims{
+		"org": "o1",
+	}
+	prefix := regexp.MustCompile(`^([^/]+)/`)
+	named := regexp.MustCompile(`^user:(?P<user>[^@]+)@(?P<tenant>.+)$`)
+
+	assert.Empty(t, claimTenant(claims, "tenant", "t1/denis", nil))
+	assert.Equal(t, "o1", claimTenant(claims, "org", "t1/denis", prefix))
+	assert.Equal(t, "t1", claimTenant(claims, "tenant", "t1/denis", prefix))
+	assert.Empty(t, claimTenant(claims, "tenant", "denis", prefix))
+	assert.Equal(t, "t2", claimTenant(claims, "tenant", "user:denis@t2", named))
+}
+
+func Test_validateClaims(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	jwt.TimeNowFn = func() time.Time { return now }
+	defer func() { jwt.TimeNowFn = time.Now }()
+
+	cfg := jwt.VerifyConfig{ExpectedIssuer: "https://issuer"}
+
+	claims := jwt.MapClaims{
+		"iss": "https://issuer",
+		"exp": now.Add(-10 * time.Second).Unix(),
+	}
+	assert.NoError(t, validateClaims(claims, cfg, 30*time.Second))
+	assert.Error(t, validateClaims(claims, cfg, 0))
+	assert.Error(t, claims.Valid(cfg))
+
+	claims = jwt.MapClaims{
+		"iss": "https://issuer",
+		"nbf": now.Add(6 * time.Minute).Unix(),
+	}
+	assert.Error(t, validateClaims(claims, cfg, 30*time.Second))
+	assert.NoError(t, validateClaims(claims, cfg, 10*time.Minute))
+
+	claims = jwt.MapClaims{
+		"iss": "https://other",
+	}
+	assert.Error(t, validateClaims(claims, cfg, 30*time.Second))
+}
+
+func Test_verifyAlg(t *testing.T) {
+	token := func(hdr string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(hdr)) + ".e30.sig"
+	}
+	m := &JWTIdentityMap{}
+
+	assert.NoError(t, verifyAlg(m, token(`{"alg":"EdDSA"}`)))
+	assert.NoError(t, verifyAlg(m, "AccessToken123"))
+	assert.EqualError(t, verifyAlg(m, token(`{"alg":"none"}`)), `alg not allowed: "none"`)
+	assert.EqualError(t, verifyAlg(m, token(`{"alg":"NONE"}`)), `alg not allowed: "NONE"`)
+	assert.EqualError(t, verifyAlg(m, token(`{"typ":"JWT"}`)), `alg not allowed: ""`)
+
+	m.AllowedAlgs = []string{"ES256", "EdDSA"}
+	assert.NoError(t, verifyAlg(m, token(`{"alg":"EdDSA"}`)))
+	assert.NoError(t, verifyAlg(m, token(`{"alg":"ES256"}`)))
+	assert.EqualError(t, verifyAlg(m, token(`{"alg":"HS256"}`)), `alg not allowed: "HS256"`)
+	assert.EqualError(t, verifyAlg(m, "AccessToken123"), "invalid token format")
+	assert.EqualError(t, verifyAlg(m, "!!.e30.sig"), "invalid token header")
+}
@@ -1,16 +1,67 @@
 package roles
 
+import "time"
+
 // IdentityMap contains configuration for the roles
 type IdentityMap struct {
 	// DebugLogs allows to add extra debog logs
 	DebugLogs bool `json:"debug_logs" yaml:"debug_logs"`
 
+	// StrictMode specifies to return ErrInvalidToken,
+	// if the provided credentials failed verification.
+	// By default, the guest identity is returned
+	StrictMode bool `json:"strict_mode" yaml:"strict_mode"`
+
+	// DenySubjects specifies the list of subjects that are resolved to guest,
+	// regardless of the valid credentials
+	DenySubjects []string `json:"deny_subjects" yaml:"deny_subjects"`
+	// AllowSubjects specifies the list of subjects that are allowed,
+	// if not empty, then any other subject is resolved to guest
+	AllowSubjects []string `json:"allow_subjects" yaml:"allow_subjects"`
+
+	// AnonymousRole specifies the role name for an unauthenticated user,
+	// by default it's `guest`
+	AnonymousRole string `json:"anonymous_role" yaml:"anonymous_role"`
+
+	// TokenMetadataKeys specifies the list of gRPC metadata keys with the token,
+	// in priority order. By default it's `authorization`
+	TokenMetadataKeys []string `json:"token_metadata_keys" yaml:"token_metadata_keys"`
+
+	// AccessTokenPrefix specifies the prefix of the access tokens,
+	// that are resolved by AccessToken provider instead of the JWT parser.
+	// If not specified, then AccessToken provider is called for any token first,
+	// and the JWT parser is used when it returns no claims.
+	// See WithAccessTokenMatcher to provide a custom matcher
+	AccessTokenPrefix string `json:"access_token_prefix" yaml:"access_token_prefix"`
+
+	// ClockSkew specifies the leeway for the time based claims of the tokens,
+	// `exp`, `nbf` and `iat`, and for the `iat` freshness of DPoP proofs,
+	// to tolerate the clients with skewed clocks.
+	// By default it's 30 seconds, a negative value disables the leeway.
+	// The jwt.Parser provided to New gets the leeway if it provides
+	// the public key, as jwt.Provider does, otherwise the parser
+	// validates the claims by its own rules, as for HMAC signed tokens
+	ClockSkew time.Duration `json:"clock_skew" yaml:"clock_skew"`
+
+	// TokenCacheSize specifies the number of verified JWT identities to cache
+	// until the token expires, by default the cache is disabled.
+	// The introspected tokens are cached no longer than IntrospectionCacheTTL
+	TokenCacheSize int `json:"token_cache_size" yaml:"token_cache_size"`
+
 	// TLS identity map
 	TLS TLSIdentityMap `json:"tls" yaml:"tls"`
 	// JWT identity map
 	JWT JWTIdentityMap `json:"jwt" yaml:"jwt"`
+	// JWTPaths specifies JWT identity maps for HTTP path prefixes,
+	// the map with the longest prefix matching the request path is used
+	// instead of JWT. It's not applied to gRPC requests
+	JWTPaths []JWTPathIdentityMap `json:"jwt_paths" yaml:"jwt_paths"`
 	// DPoP identity map
 	DPoP JWTIdentityMap `json:"jwt_dpop" yaml:"jwt_dpop"`
+	// APIKey identity map
+	APIKey APIKeyIdentityMap `json:"api_key" yaml:"api_key"`
+	// Basic identity map
+	Basic BasicIdentityMap `json:"basic" yaml:"basic"`
 }
 
 // TLSIdentityMap provides roles for TLS
@@ -19,7 +70,14 @@ type TLSIdentityMap struct {
 	DefaultAuthenticatedRole string `json:"default_authenticated_role" yaml:"default_authenticated_role"`
 	// Enable TLS identities
 	Enabled bool `json:"enabled" yaml:"enabled"`
-	// Roles is a map of role to TLS identity
+	// MatchField specifies the certificate field to match Roles against:
+	// `uri` for SPIFFE URI, `dns` for DNS SAN, or `cn` for Subject Common Name.
+	// By default it's `uri`
+	MatchField string `json:"match_field" yaml:"match_field"`
+	// Roles is a map of role to TLS identity.
+	// With `uri` MatchField, the identity ending with `/*` matches
+	// any SPIFFE ID in the trust domain under the path,
+	// for example `spiffe://trusty/client/*` matches `spiffe://trusty/client/123`
 	Roles map[string][]string `json:"roles" yaml:"roles"`
 }
 
@@ -31,17 +89,117 @@ type JWTIdentityMap struct {
 	Enabled bool `json:"enabled" yaml:"enabled"`
 	// Issuer specifies the token issuer to check for
 	Issuer string `json:"issuer" yaml:"issuer"`
+	// Issuers specifies the list of token issuers to check for,
+	// the token is accepted if its issuer matches any of the list
+	Issuers []string `json:"issuers" yaml:"issuers"`
 	// Audience specifies the token audience to check for
 	Audience string `json:"audience" yaml:"audience"`
+	// Audiences specifies the list of token audiences to check for,
+	// the token is accepted if any of its audiences matches the list
+	Audiences []string `json:"audiences" yaml:"audiences"`
+	// JWKSURL specifies the URL to fetch the token signing keys from,
+	// if not specified, then the keys of the provided jwt.Parser are used
+	JWKSURL string `json:"jwks_url" yaml:"jwks_url"`
+	// JWKSCacheTTL specifies the interval to refresh the signing keys from JWKSURL,
+	// by default it's one hour
+	JWKSCacheTTL time.Duration `json:"jwks_cache_ttl" yaml:"jwks_cache_ttl"`
+	// IntrospectionURL specifies RFC 7662 token introspection endpoint,
+	// that is used for the access tokens that are not JWT
+	IntrospectionURL string `json:"introspection_url" yaml:"introspection_url"`
+	// IntrospectionClientID specifies client ID to authenticate with IntrospectionURL
+	IntrospectionClientID string `json:"introspection_client_id" yaml:"introspection_client_id"`
+	// IntrospectionClientSecret specifies client secret to authenticate with IntrospectionURL
+	IntrospectionClientSecret string `json:"introspection_client_secret" yaml:"introspection_client_secret"`
+	// IntrospectionCacheTTL specifies the interval to cache the introspection response,
+	// by default it's one minute.
+	// The token revoked at the authorization server is accepted up to this interval
+	IntrospectionCacheTTL time.Duration `json:"introspection_cache_ttl" yaml:"introspection_cache_ttl"`
+	// CookieName specifies the cookie name with the token,
+	// that is used when Authorization header is not present
+	CookieName string `json:"cookie_name" yaml:"cookie_name"`
+	// RequireCertBinding specifies to accept only the tokens bound to
+	// the client certificate of TLS connection, see RFC 8705.
+	// The `cnf` claim must contain `x5t#S256` thumbprint of the certificate
+	RequireCertBinding bool `json:"require_cert_binding" yaml:"require_cert_binding"`
+	// RequireIPBinding specifies to accept only the tokens bound to
+	// the client IP, that is specified in IPClaim. The client IP is captured
+	// by correlation handler with the trusted proxy logic, if available,
+	// otherwise the remote address of the connection is used
+	RequireIPBinding bool `json:"require_ip_binding" yaml:"require_ip_binding"`
+	// IPClaim specifies claim name with the client IP for RequireIPBinding,
+	// by default it's `ip`
+	IPClaim string `json:"ip_claim" yaml:"ip_claim"`
+	// AllowedAlgs specifies the list of allowed token signing algorithms,
+	// for example `ES256`, `RS256`. If not specified, then any algorithm
+	// supported by jwt.Parser is accepted. The `none` algorithm is always rejected
+	AllowedAlgs []string `json:"allowed_algs" yaml:"allowed_algs"`
 	// SubjectClaim specifies claim name to be used as Subject,
 	// by default it's `sub`, but can be changed to `email` etc
 	SubjectClaim string `json:"subject_claim" yaml:"subject_claim"`
 	// RoleClaim specifies claim name to be used for role mapping,
-	// by default it's `email`, but can be changed to `sub` etc
+	// by default it's `email`, but can be changed to `sub` etc.
+	// If the claim is an array, for example `groups`, then the role
+	// is mapped from the first element that is found in Roles
 	RoleClaim string `json:"role_claim" yaml:"role_claim"`
+	// NormalizeClaims specifies to lowercase and trim the values of RoleClaim
+	// and the identities in Roles before matching,
+	// for example for case-insensitive email matching
+	NormalizeClaims bool `json:"normalize_claims" yaml:"normalize_claims"`
 	// TenantClaim specifies claim name to be used for tenant mapping,
 	// by default it's `tenant`, but can be changed to `org` etc
 	TenantClaim string `json:"tenant_claim" yaml:"tenant_claim"`
+	// TenantFromSubjectPattern specifies the regular expression applied to the Subject
+	// to extract tenant, when TenantClaim is not present in the token,
+	// for example `^([^/]+)/`. The `tenant` named group is used if present,
+	// otherwise the first group
+	TenantFromSubjectPattern string `json:"tenant_from_subject_pattern" yaml:"tenant_from_subject_pattern"`
+	// ScopeClaim specifies claim name to be used for the identity scopes,
+	// for example `scope`, the value is split by spaces.
+	// If not specified, then the scopes are not populated
+	ScopeClaim string `json:"scope_claim" yaml:"scope_claim"`
 	// Roles is a map of role to JWT identity
 	Roles map[string][]string `json:"roles" yaml:"roles"`
 }
+
+// JWTPathIdentityMap provides JWT identity map for HTTP path prefix
+type JWTPathIdentityMap struct {
+	// PathPrefix specifies the prefix of HTTP request path, for example `/v1/admin/`
+	PathPrefix string `json:"path_prefix" yaml:"path_prefix"`
+	// JWT identity map for the requests with the path prefix
+	JWT JWTIdentityMap `json:"jwt" yaml:"jwt"`
+}
+
+// APIKeyIdentityMap provides identities for static API keys
+type APIKeyIdentityMap struct {
+	// Enable API key identities
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// HeaderName specifies the header name with API key,
+	// by default it's `X-API-Key`
+	HeaderName string `json:"header_name" yaml:"header_name"`
+	// Keys is a map of API key to identity
+	Keys map[string]APIKeyIdentity `json:"keys" yaml:"keys"`
+}
+
+// APIKeyIdentity provides identity for API key
+type APIKeyIdentity struct {
+	// Role specifies role name for identity
+	Role string `json:"role" yaml:"role"`
+	// Subject specifies the identity subject
+	Subject string `json:"subject" yaml:"subject"`
+	// Tenant specifies the identity tenant
+	Tenant string `json:"tenant" yaml:"tenant"`
+}
+
+// BasicIdentityMap provides roles for HTTP Basic authentication
+type BasicIdentityMap struct {
+	// DefaultAuthenticatedRole specifies role name for identity, if not found in maps
+	DefaultAuthenticatedRole string `json:"default_authenticated_role" yaml:"default_authenticated_role"`
+	// Enable Basic identities
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// Users is a map of user name to bcrypt hash of the password.
+	// The unknown users are verified against a hash with bcrypt.DefaultCost,
+	// use the same cost to keep the response time indistinguishable
+	Users map[string]string `json:"users" yaml:"users"`
+	// Roles is a map of role to user names
+	Roles map[string][]string `json:"roles" yaml:"roles"`
+}
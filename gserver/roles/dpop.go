@@ -0,0 +1,101 @@
+package roles
+
+import (
+	"strings"
+	"time"
+
+	"github.com/effective-security/xpki/jwt"
+	"github.com/effective-security/xpki/jwt/dpop"
+	"github.com/pkg/errors"
+	"gopkg.in/square/go-jose.v2"
+	josejwt "gopkg.in/square/go-jose.v2/jwt"
+)
+
+// dpopHeaderType specifies `typ` header of DPoP proof
+const dpopHeaderType = "dpop+jwt"
+
+// dpopAllowedAlgs specifies the asymmetric algorithms allowed for DPoP proof
+var dpopAllowedAlgs = map[jose.SignatureAlgorithm]bool{
+	jose.RS256: true,
+	jose.RS384: true,
+	jose.RS512: true,
+	jose.ES256: true,
+	jose.ES384: true,
+	jose.ES512: true,
+	jose.PS256: true,
+	jose.PS384: true,
+	jose.PS512: true,
+	jose.EdDSA: true,
+}
+
+// verifyDPoPProof returns the verified claims of DPoP proof.
+// The proof is checked as dpop.VerifyClaims does,
+// and the `iat` freshness is checked with the skew leeway
+func verifyDPoPProof(phdr, method, uri string, skew time.Duration) (*dpop.Result, error) {
+	pjwt, err := josejwt.ParseSigned(phdr)
+	if err != nil {
+		return nil, errors.WithMessage(err, "dpop: failed to parse header")
+	}
+	if len(pjwt.Headers) != 1 {
+		return nil, errors.New("dpop: token contains multiple headers")
+	}
+	hdr := pjwt.Headers[0]
+	if typ, _ := hdr.ExtraHeaders["typ"].(string); typ != dpopHeaderType {
+		return nil, errors.New("dpop: invalid typ header")
+	}
+	pjwk := hdr.JSONWebKey
+	if pjwk == nil {
+		return nil, errors.New("dpop: jwk field not found in header")
+	}
+	if !pjwk.IsPublic() {
+		return nil, errors.New("dpop: jwk field in header must be public key")
+	}
+	if alg := jose.SignatureAlgorithm(hdr.Algorithm); !dpopAllowedAlgs[alg] {
+		return nil, errors.Errorf("dpop: alg not allowed: %s", alg)
+	}
+
+	claims := &jwt.Claims{}
+	mc := jwt.MapClaims{}
+	if err = pjwt.Claims(pjwk.Public().Key, claims, &mc); err != nil {
+		return nil, errors.WithMessage(err, "dpop: failed to verify token")
+	}
+	if claims.ID == "" {
+		return nil, errors.New("dpop: claim not found: jti")
+	}
+	if claims.HTTPMethod == "" {
+		return nil, errors.New("dpop: claim not found: http_method")
+	}
+	if claims.HTTPUri == "" {
+		return nil, errors.New("dpop: claim not found: http_uri")
+	}
+	if claims.IssuedAt == nil {
+		return nil, errors.New("dpop: claim not found: iat")
+	}
+	if !strings.EqualFold(claims.HTTPMethod, method) {
+		return nil, errors.Errorf("dpop: claim mismatch: http_method: %q, actual: %q",
+			claims.HTTPMethod, method)
+	}
+	if !strings.EqualFold(claims.HTTPUri, uri) {
+		return nil, errors.Errorf("dpop: claim mismatch: http_uri: %q, actual: %q",
+			claims.HTTPUri, uri)
+	}
+
+	now := dpop.TimeNowFn()
+	iat := claims.IssuedAt.Time()
+	if now.Sub(iat) > dpop.DefaultExpiration+skew {
+		return nil, errors.Errorf("dpop: iat claim expired: %s", iat.String())
+	}
+	if err = validateTimeClaims(mc, now, skew); err != nil {
+		return nil, errors.WithMessage(err, "dpop: failed to verify token")
+	}
+
+	tb, err := dpop.Thumbprint(pjwk)
+	if err != nil {
+		return nil, err
+	}
+	return &dpop.Result{
+		Claims:     claims,
+		Key:        pjwk,
+		Thumbprint: tb,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package roles
+
+import (
+	"sync"
+	"time"
+
+	"github.com/effective-security/xpki/jwt/dpop"
+)
+
+// DPoPReplayStore provides replay protection for DPoP proofs.
+//
+// The default in-memory store is local to the process,
+// for multi-instance deployments provide a shared store,
+// for example backed by Redis `SET jti 1 NX PXAT exp`,
+// with WithDPoPReplayStore option.
+type DPoPReplayStore interface {
+	// Seen records the proof `jti` until `exp`,
+	// and returns true if the `jti` was already recorded
+	Seen(jti string, exp time.Time) bool
+}
+
+// dpopReplayPurgeInterval specifies the interval to purge expired entries
+var dpopReplayPurgeInterval = time.Minute
+
+type memoryReplayStore struct {
+	lock     sync.Mutex
+	seen     map[string]time.Time
+	purgedAt time.Time
+}
+
+// NewDPoPReplayStore returns in-memory DPoPReplayStore,
+// the entries are evicted when expired
+func NewDPoPReplayStore() DPoPReplayStore {
+	return &memoryReplayStore{
+		seen: map[string]time.Time{},
+	}
+}
+
+// Seen records the proof `jti` until `exp`,
+// and returns true if the `jti` was already recorded
+func (s *memoryReplayStore) Seen(jti string, exp time.Time) bool {
+	// use the same clock as DPoP proof verification
+	now := dpop.TimeNowFn()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if now.Sub(s.purgedAt) > dpopReplayPurgeInterval {
+		for k, e := range s.seen {
+			if now.After(e) {
+				delete(s.seen, k)
+			}
+		}
+		s.purgedAt = now
+	}
+
+	if e, ok := s.seen[jti]; ok && !now.After(e) {
+		return true
+	}
+	s.seen[jti] = exp
+	return false
+}
@@ -0,0 +1,28 @@
+package roles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/effective-security/xpki/jwt/dpop"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_memoryReplayStore(t *testing.T) {
+	now := time.Now()
+	dpop.TimeNowFn = func() time.Time { return now }
+	defer func() { dpop.TimeNowFn = time.Now }()
+
+	s := NewDPoPReplayStore().(*memoryReplayStore)
+	assert.False(t, s.Seen("jti1", now.Add(time.Minute)))
+	assert.True(t, s.Seen("jti1", now.Add(time.Minute)))
+	assert.False(t, s.Seen("jti2", now.Add(time.Hour)))
+	assert.Len(t, s.seen, 2)
+
+	// expired entry is not reported, and purged
+	now = now.Add(2 * time.Minute)
+	assert.False(t, s.Seen("jti1", now.Add(time.Minute)))
+	now = now.Add(2 * time.Minute)
+	assert.True(t, s.Seen("jti2", now.Add(time.Hour)))
+	assert.Len(t, s.seen, 1)
+}
@@ -0,0 +1,32 @@
+package roles
+
+import "github.com/pkg/errors"
+
+// ErrInvalidToken is returned in StrictMode,
+// when the provided credentials failed verification.
+// Use errors.Is to check for it.
+var ErrInvalidToken = errors.New("invalid token")
+
+// invalidTokenError wraps the reason of verification failure
+type invalidTokenError struct {
+	reason error
+}
+
+func (e *invalidTokenError) Error() string {
+	return ErrInvalidToken.Error() + ": " + e.reason.Error()
+}
+
+// Unwrap returns the reason of verification failure
+func (e *invalidTokenError) Unwrap() error {
+	return e.reason
+}
+
+// Is returns true for ErrInvalidToken
+func (e *invalidTokenError) Is(target error) bool {
+	return target == ErrInvalidToken
+}
+
+// ErrPermissionDenied is returned by Authorizer,
+// when the identity is not allowed to access the method.
+// Use errors.Is to check for it.
+var ErrPermissionDenied = errors.New("permission denied")
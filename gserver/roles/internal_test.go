@@ -1,9 +1,17 @@
 package roles
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -70,3 +78,108 @@ func Test_dumpDM(t *testing.T) {
 		assert.Equal(t, tc.exp, vals)
 	}
 }
+
+func Test_parseBasicAuth(t *testing.T) {
+	u, p, ok := parseBasicAuth("dXNlcjpwYXNzOndvcmQ=")
+	assert.True(t, ok)
+	assert.Equal(t, "user", u)
+	assert.Equal(t, "pass:word", p)
+
+	_, _, ok = parseBasicAuth("dXNlcg==")
+	assert.False(t, ok)
+	_, _, ok = parseBasicAuth("!!")
+	assert.False(t, ok)
+}
+
+func Test_findSPIFFERoles(t *testing.T) {
+	patterns, err := newSPIFFEPatterns(map[string][]string{
+		"spiffe://trusty/client":   {"exact"},
+		"spiffe://trusty/client/*": {"client"},
+		"spiffe://Trusty/*":        {"trusty"},
+		"spiffe://other/client/*":  {"other"},
+	})
+	require.NoError(t, err)
+	require.Len(t, patterns, 3)
+
+	tcases := []struct {
+		id  string
+		exp []string
+	}{
+		{"spiffe://trusty/client/123", []string{"trusty", "client"}},
+		{"spiffe://TRUSTY/client/123/a", []string{"trusty", "client"}},
+		{"spiffe://trusty/client", []string{"trusty"}},
+		{"spiffe://trusty/clients/1", []string{"trusty"}},
+		{"spiffe://other/client/1", []string{"other"}},
+		{"spiffe://trusty.evil/client/1", nil},
+		{"spiffe://trusty/client/../admin", nil},
+		{"spiffe://trusty:8443/client/1", nil},
+		{"spiffe://user@trusty/client/1", nil},
+		{"spiffe://trusty/client/1?q=1", nil},
+		{"https://trusty/client/1", nil},
+	}
+	for _, tc := range tcases {
+		assert.Equal(t, tc.exp, findSPIFFERoles(patterns, tc.id, nil), tc.id)
+	}
+
+	exact := []string{"exact"}
+	assert.Equal(t, []string{"exact", "trusty", "client"}, findSPIFFERoles(patterns, "spiffe://trusty/client/1", exact))
+	assert.Equal(t, []string{"exact"}, exact)
+	assert.Equal(t, exact, findSPIFFERoles(nil, "spiffe://trusty/client/1", exact))
+
+	_, err = newSPIFFEPatterns(map[string][]string{"spiffe:///*": {"r"}})
+	assert.EqualError(t, err, "invalid SPIFFE pattern: spiffe:///*: invalid trust domain")
+	_, err = newSPIFFEPatterns(map[string][]string{"spiffe://trusty/a/../*": {"r"}})
+	assert.EqualError(t, err, "invalid SPIFFE pattern: spiffe://trusty/a/../*: invalid path")
+}
+
+func Test_introspectorCache(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+	}))
+	defer srv.Close()
+
+	max := introspectionMaxEntries
+	introspectionMaxEntries = 3
+	defer func() { introspectionMaxEntries = max }()
+
+	c := newIntrospector(&JWTIdentityMap{IntrospectionURL: srv.URL})
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		_, err := c.Claims(ctx, fmt.Sprintf("junk%d", i))
+		assert.EqualError(t, err, "token is not active")
+	}
+	assert.Equal(t, int32(10), atomic.LoadInt32(&calls))
+	assert.Len(t, c.cache, 3)
+	assert.Equal(t, 3, c.ll.Len())
+
+	// the recent entries are cached
+	_, err := c.Claims(ctx, "junk9")
+	assert.Error(t, err)
+	assert.Equal(t, int32(10), atomic.LoadInt32(&calls))
+	// the oldest entries are evicted
+	_, err = c.Claims(ctx, "junk0")
+	assert.Error(t, err)
+	assert.Equal(t, int32(11), atomic.LoadInt32(&calls))
+	assert.Len(t, c.cache, 3)
+}
+
+func Test_introspectorContext(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := newIntrospector(&JWTIdentityMap{IntrospectionURL: srv.URL})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := c.Claims(ctx, "token")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "context deadline exceeded")
+	assert.Less(t, time.Since(started), 5*time.Second)
+}
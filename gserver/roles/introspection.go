@@ -0,0 +1,178 @@
+package roles
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/effective-security/porto/xhttp/header"
+	"github.com/effective-security/xpki/jwt"
+	"github.com/pkg/errors"
+)
+
+// DefaultIntrospectionCacheTTL specifies the default interval to cache the introspection response
+const DefaultIntrospectionCacheTTL = time.Minute
+
+// introspectionNegativeTTL specifies the interval to cache inactive tokens
+var introspectionNegativeTTL = 10 * time.Second
+
+// introspectionMaxEntries limits the cache size,
+// the least recently used entries are evicted
+var introspectionMaxEntries = 1024
+
+type introspectionEntry struct {
+	key       string
+	claims    jwt.MapClaims
+	expiresAt time.Time
+}
+
+// introspector validates opaque access tokens with RFC 7662 endpoint
+type introspector struct {
+	url          string
+	clientID     string
+	clientSecret string
+	ttl          time.Duration
+	client       *http.Client
+
+	lock  sync.Mutex
+	ll    *list.List
+	cache map[string]*list.Element
+}
+
+func newIntrospector(m *JWTIdentityMap) *introspector {
+	ttl := m.IntrospectionCacheTTL
+	if ttl == 0 {
+		ttl = DefaultIntrospectionCacheTTL
+	}
+	return &introspector{
+		url:          m.IntrospectionURL,
+		clientID:     m.IntrospectionClientID,
+		clientSecret: m.IntrospectionClientSecret,
+		ttl:          ttl,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		ll:           list.New(),
+		cache:        map[string]*list.Element{},
+	}
+}
+
+// isJWT returns true if the token has JWS compact serialization format
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// Claims returns claims of the active token,
+// or error if the token is not active
+func (c *introspector) Claims(ctx context.Context, token string) (jwt.MapClaims, error) {
+	h := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(h[:])
+	now := time.Now()
+
+	entry := c.get(key, now)
+	if entry == nil {
+		claims, err := c.introspect(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+
+		entry = &introspectionEntry{
+			key:       key,
+			claims:    claims,
+			expiresAt: now.Add(c.ttl),
+		}
+		if claims == nil {
+			entry.expiresAt = now.Add(introspectionNegativeTTL)
+		} else if exp := claims.Time("exp"); exp != nil && exp.Before(entry.expiresAt) {
+			entry.expiresAt = *exp
+		}
+		c.add(entry)
+	}
+
+	if entry.claims == nil {
+		return nil, errors.Errorf("token is not active")
+	}
+	return entry.claims, nil
+}
+
+// get returns the cached entry, or nil if not found or expired
+func (c *introspector) get(key string, now time.Time) *introspectionEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.cache[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*introspectionEntry)
+	if now.After(entry.expiresAt) {
+		c.ll.Remove(el)
+		delete(c.cache, key)
+		return nil
+	}
+	c.ll.MoveToFront(el)
+	return entry
+}
+
+// add adds the entry to the cache,
+// and evicts the least recently used entries over the limit
+func (c *introspector) add(entry *introspectionEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.cache[entry.key]; ok {
+		el.Value = entry
+		c.ll.MoveToFront(el)
+		return
+	}
+	for c.ll.Len() >= introspectionMaxEntries {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.cache, el.Value.(*introspectionEntry).key)
+	}
+	c.cache[entry.key] = c.ll.PushFront(entry)
+}
+
+// introspect returns claims of the active token,
+// or nil if the token is not active
+func (c *introspector) introspect(ctx context.Context, token string) (jwt.MapClaims, error) {
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set(header.ContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(header.Accept, header.ApplicationJSON)
+	if c.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "unable to introspect token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unable to introspect token: %s", resp.Status)
+	}
+
+	var claims jwt.MapClaims
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err = dec.Decode(&claims); err != nil {
+		return nil, errors.WithMessagef(err, "unable to decode introspection response")
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, nil
+	}
+	return claims, nil
+}
@@ -0,0 +1,138 @@
+package roles_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/effective-security/porto/gserver/roles"
+	"github.com/effective-security/porto/xhttp/identity"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntrospection(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+
+		user, secret, ok := r.BasicAuth()
+		if !ok || user != "client" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		res := map[string]interface{}{
+			"active": false,
+		}
+		if r.PostFormValue("token") == "active_token" {
+			res = map[string]interface{}{
+				"active": true,
+				"sub":    "12234",
+				"iss":    "https://issuer",
+				"email":  "denis@trusty.ca",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+			}
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+
+	cfg := &roles.IdentityMap{
+		JWT: roles.JWTIdentityMap{
+			Enabled:                   true,
+			DefaultAuthenticatedRole:  "jwt_authenticated",
+			Issuer:                    "https://issuer",
+			IntrospectionURL:          srv.URL,
+			IntrospectionClientID:     "client",
+			IntrospectionClientSecret: "secret",
+			Roles: map[string][]string{
+				"trusty-client": {"denis@trusty.ca"},
+			},
+		},
+	}
+	p, err := roles.New(cfg, mockJWT{}, nil)
+	require.NoError(t, err)
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	setAuthorizationHeader(r, "active_token")
+	for i := 0; i < 3; i++ {
+		id, err := p.IdentityFromRequest(r)
+		require.NoError(t, err)
+		assert.Equal(t, "trusty-client", id.Role())
+		assert.Equal(t, "12234", id.Subject())
+	}
+	// the response is cached
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	setAuthorizationHeader(r, "inactive_token")
+	for i := 0; i < 3; i++ {
+		id, err := p.IdentityFromRequest(r)
+		require.NoError(t, err)
+		assert.Equal(t, identity.GuestRoleName, id.Role())
+	}
+	// the negative response is cached
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// invalid client credentials
+	cfg.JWT.IntrospectionClientSecret = "invalid"
+	p, err = roles.New(cfg, mockJWT{}, nil)
+	require.NoError(t, err)
+
+	setAuthorizationHeader(r, "active_token")
+	id, err := p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, identity.GuestRoleName, id.Role())
+}
+
+func TestIntrospectionRevoked(t *testing.T) {
+	var revoked int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := map[string]interface{}{
+			"active": false,
+		}
+		if atomic.LoadInt32(&revoked) == 0 {
+			res = map[string]interface{}{
+				"active": true,
+				"sub":    "12234",
+				"iss":    "https://issuer",
+				"email":  "denis@trusty.ca",
+				"exp":    time.Now().Add(time.Hour).Unix(),
+			}
+		}
+		_ = json.NewEncoder(w).Encode(res)
+	}))
+	defer srv.Close()
+
+	cfg := &roles.IdentityMap{
+		TokenCacheSize: 10,
+		JWT: roles.JWTIdentityMap{
+			Enabled:                  true,
+			DefaultAuthenticatedRole: "jwt_authenticated",
+			Issuer:                   "https://issuer",
+			IntrospectionURL:         srv.URL,
+			IntrospectionCacheTTL:    100 * time.Millisecond,
+		},
+	}
+	p, err := roles.New(cfg, mockJWT{}, nil)
+	require.NoError(t, err)
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	setAuthorizationHeader(r, "opaque_token")
+	id, err := p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "jwt_authenticated", id.Role())
+
+	atomic.StoreInt32(&revoked, 1)
+	id, err = p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "jwt_authenticated", id.Role())
+
+	time.Sleep(150 * time.Millisecond)
+	id, err = p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, identity.GuestRoleName, id.Role())
+}
@@ -0,0 +1,58 @@
+package roles
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/effective-security/porto/xhttp/correlation"
+	"github.com/effective-security/xpki/jwt"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/peer"
+)
+
+// clientIP returns the client address of HTTP request,
+// captured by correlation handler with the trusted proxy logic,
+// or the remote address of the connection
+func clientIP(r *http.Request) string {
+	if ip := correlation.ClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	return hostOnly(r.RemoteAddr)
+}
+
+// clientIPFromContext returns the client address of gRPC request,
+// captured by correlation handler, or the address of gRPC peer
+func clientIPFromContext(ctx context.Context) string {
+	if ip := correlation.ClientIP(ctx); ip != "" {
+		return ip
+	}
+	c, ok := peer.FromContext(ctx)
+	if !ok || c.Addr == nil {
+		return ""
+	}
+	return hostOnly(c.Addr.String())
+}
+
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// verifyIPBinding checks the IP claim against the client address
+func verifyIPBinding(claims jwt.MapClaims, claim, ip string) error {
+	if ip == "" {
+		return errors.Errorf("client IP is required for IP-bound token")
+	}
+	val := claimString(claims, claim)
+	if val == "" {
+		return errors.Errorf("%s claim not found", claim)
+	}
+	bound := net.ParseIP(val)
+	if bound == nil || !bound.Equal(net.ParseIP(ip)) {
+		return errors.Errorf("client IP mismatch: %s", ip)
+	}
+	return nil
+}
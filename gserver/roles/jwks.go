@@ -0,0 +1,141 @@
+package roles
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/effective-security/xlog"
+	"github.com/effective-security/xpki/jwt"
+	"github.com/pkg/errors"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// DefaultJWKSCacheTTL specifies the default interval to refresh JWKS
+const DefaultJWKSCacheTTL = time.Hour
+
+// jwksMinRefreshInterval limits the refresh rate on unknown `kid`
+var jwksMinRefreshInterval = 10 * time.Second
+
+// jwksCache provides the signing keys from a remote JWKS endpoint,
+// and implements jwt.Parser interface
+type jwksCache struct {
+	url    string
+	ttl    time.Duration
+	skew   time.Duration
+	client *http.Client
+
+	lock      sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+func newJWKSCache(url string, ttl, skew time.Duration) *jwksCache {
+	if ttl == 0 {
+		ttl = DefaultJWKSCacheTTL
+	}
+	return &jwksCache{
+		url:    url,
+		ttl:    ttl,
+		skew:   skew,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   map[string]interface{}{},
+	}
+}
+
+// ParseToken returns claims of the verified token
+func (c *jwksCache) ParseToken(authorization string, cfg jwt.VerifyConfig) (jwt.MapClaims, error) {
+	// the claims are validated with the clock skew leeway after the signature
+	parser := jwt.TokenParser{
+		UseJSONNumber:        true,
+		SkipClaimsValidation: true,
+	}
+	claims := jwt.MapClaims{}
+	token, err := parser.ParseWithClaims(authorization, cfg, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.Errorf("missing kid")
+		}
+		return c.key(kid)
+	})
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to verify token")
+	}
+	if !token.Valid {
+		return nil, errors.Errorf("invalid token")
+	}
+	if err = validateClaims(claims, cfg, c.skew); err != nil {
+		return nil, errors.WithMessage(err, "failed to verify token")
+	}
+	return claims, nil
+}
+
+// key returns the public key for `kid`,
+// the keys are refreshed on cache miss or when TTL expired
+func (c *jwksCache) key(kid string) (interface{}, error) {
+	c.lock.RLock()
+	key, ok := c.keys[kid]
+	fetchedAt := c.fetchedAt
+	c.lock.RUnlock()
+
+	now := time.Now()
+	expired := now.After(fetchedAt.Add(c.ttl))
+	if ok && !expired {
+		return key, nil
+	}
+	if !ok && !expired && now.Before(fetchedAt.Add(jwksMinRefreshInterval)) {
+		return nil, errors.Errorf("key not found: %s", kid)
+	}
+
+	if err := c.refresh(); err != nil {
+		logger.KV(xlog.ERROR, "url", c.url, "err", err.Error())
+		if ok {
+			// use the stale key
+			return key, nil
+		}
+		return nil, err
+	}
+
+	c.lock.RLock()
+	key, ok = c.keys[kid]
+	c.lock.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("key not found: %s", kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the keys from JWKS endpoint
+func (c *jwksCache) refresh() error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return errors.WithMessagef(err, "unable to fetch JWKS")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unable to fetch JWKS: %s", resp.Status)
+	}
+
+	var jwks jose.JSONWebKeySet
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return errors.WithMessagef(err, "unable to decode JWKS")
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.KeyID == "" || !k.Valid() {
+			continue
+		}
+		keys[k.KeyID] = k.Public().Key
+	}
+
+	c.lock.Lock()
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	c.lock.Unlock()
+
+	logger.KV(xlog.DEBUG, "url", c.url, "keys", len(keys))
+	return nil
+}
@@ -0,0 +1,253 @@
+package roles_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/effective-security/porto/gserver/roles"
+	"github.com/effective-security/xpki/jwt"
+	"github.com/effective-security/xpki/jwt/dpop"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestJWKS(t *testing.T) {
+	now := time.Now()
+	jwt.TimeNowFn = func() time.Time { return now }
+	dpop.TimeNowFn = jwt.TimeNowFn
+	defer func() {
+		jwt.TimeNowFn = time.Now
+		dpop.TimeNowFn = time.Now
+	}()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := jwt.NewFromCryptoSigner(key, jwt.WithHeaders(map[string]interface{}{"kid": "k1"}))
+	require.NoError(t, err)
+	unknownSigner, err := jwt.NewFromCryptoSigner(key, jwt.WithHeaders(map[string]interface{}{"kid": "unknown"}))
+	require.NoError(t, err)
+
+	var fetched int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetched, 1)
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{
+				{Key: key.Public(), KeyID: "k1", Algorithm: "ES256", Use: "sig"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	p, err := roles.New(&roles.IdentityMap{
+		JWT: roles.JWTIdentityMap{
+			Enabled:                  true,
+			DefaultAuthenticatedRole: "jwt_authenticated",
+			Issuer:                   "https://issuer",
+			JWKSURL:                  srv.URL,
+			JWKSCacheTTL:             time.Hour,
+			Roles: map[string][]string{
+				"trusty-client": {"denis@trusty.ca"},
+			},
+		},
+	}, nil, nil)
+	require.NoError(t, err)
+
+	claims := jwt.CreateClaims("", "12234", "https://issuer", nil, time.Hour, jwt.MapClaims{
+		"email": "denis@trusty.ca",
+	})
+	token, err := signer.Sign(claims)
+	require.NoError(t, err)
+
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	setAuthorizationHeader(r, token)
+	id, err := p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "trusty-client", id.Role())
+	assert.Equal(t, "12234", id.Subject())
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", token))
+	id, err = p.IdentityFromContext(ctx, "/test")
+	require.NoError(t, err)
+	assert.Equal(t, "trusty-client", id.Role())
+
+	// the keys are cached
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+
+	token, err = unknownSigner.Sign(claims)
+	require.NoError(t, err)
+	setAuthorizationHeader(r, token)
+	id, err = p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "guest", id.Role())
+}
+
+func TestJWKSClockSkew(t *testing.T) {
+	now := time.Now()
+	jwt.TimeNowFn = func() time.Time { return now }
+	dpop.TimeNowFn = jwt.TimeNowFn
+	defer func() {
+		jwt.TimeNowFn = time.Now
+		dpop.TimeNowFn = time.Now
+	}()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	signer, err := jwt.NewFromCryptoSigner(key, jwt.WithHeaders(map[string]interface{}{"kid": "k1"}))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{
+				{Key: key.Public(), KeyID: "k1", Algorithm: "ES256", Use: "sig"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	cfg := &roles.IdentityMap{
+		JWT: roles.JWTIdentityMap{
+			Enabled:                  true,
+			DefaultAuthenticatedRole: "jwt_authenticated",
+			JWKSURL:                  srv.URL,
+		},
+	}
+
+	sign := func(expired time.Duration) string {
+		claims := jwt.CreateClaims("", "12234", "https://issuer", nil, time.Hour, nil)
+		claims["exp"] = now.Add(-expired).Unix()
+		token, err := signer.Sign(claims)
+		require.NoError(t, err)
+		return token
+	}
+
+	p, err := roles.New(cfg, nil, nil)
+	require.NoError(t, err)
+
+	tcases := []struct {
+		expired time.Duration
+		role    string
+	}{
+		// expired within the default skew
+		{5 * time.Second, "jwt_authenticated"},
+		{time.Minute, "guest"},
+	}
+	for _, tc := range tcases {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		setAuthorizationHeader(r, sign(tc.expired))
+		id, err := p.IdentityFromRequest(r)
+		require.NoError(t, err)
+		assert.Equal(t, tc.role, id.Role(), tc.expired)
+	}
+
+	cfg.ClockSkew = 2 * time.Minute
+	p, err = roles.New(cfg, nil, nil)
+	require.NoError(t, err)
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	setAuthorizationHeader(r, sign(time.Minute))
+	id, err := p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "jwt_authenticated", id.Role())
+
+	// disabled
+	cfg.ClockSkew = -1
+	p, err = roles.New(cfg, nil, nil)
+	require.NoError(t, err)
+	setAuthorizationHeader(r, sign(5*time.Second))
+	id, err = p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "guest", id.Role())
+
+	// the parser provided to New with the public key
+	cfg.ClockSkew = 0
+	cfg.JWT.JWKSURL = ""
+	p, err = roles.New(cfg, signer, nil)
+	require.NoError(t, err)
+	setAuthorizationHeader(r, sign(5*time.Second))
+	id, err = p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "jwt_authenticated", id.Role())
+
+	setAuthorizationHeader(r, sign(time.Minute))
+	id, err = p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "guest", id.Role())
+
+	setAuthorizationHeader(r, sign(-time.Minute))
+	id, err = p.IdentityFromRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "jwt_authenticated", id.Role())
+}
+
+func TestJWKSAllowedAlgs(t *testing.T) {
+	now := time.Now()
+	jwt.TimeNowFn = func() time.Time { return now }
+	dpop.TimeNowFn = jwt.TimeNowFn
+	defer func() {
+		jwt.TimeNowFn = time.Now
+		dpop.TimeNowFn = time.Now
+	}()
+
+	key256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	key384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	signer256, err := jwt.NewFromCryptoSigner(key256, jwt.WithHeaders(map[string]interface{}{"kid": "k256"}))
+	require.NoError(t, err)
+	signer384, err := jwt.NewFromCryptoSigner(key384, jwt.WithHeaders(map[string]interface{}{"kid": "k384"}))
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{
+				{Key: key256.Public(), KeyID: "k256", Algorithm: "ES256", Use: "sig"},
+				{Key: key384.Public(), KeyID: "k384", Algorithm: "ES384", Use: "sig"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	cfg := &roles.IdentityMap{
+		JWT: roles.JWTIdentityMap{
+			Enabled:                  true,
+			DefaultAuthenticatedRole: "jwt_authenticated",
+			JWKSURL:                  srv.URL,
+		},
+	}
+	claims := jwt.CreateClaims("", "12234", "https://issuer", nil, time.Hour, nil)
+	token256, err := signer256.Sign(claims)
+	require.NoError(t, err)
+	token384, err := signer384.Sign(claims)
+	require.NoError(t, err)
+
+	roleFor := func(p roles.IdentityProvider, token string) string {
+		r, _ := http.NewRequest(http.MethodGet, "/", nil)
+		setAuthorizationHeader(r, token)
+		id, err := p.IdentityFromRequest(r)
+		require.NoError(t, err)
+		return id.Role()
+	}
+
+	p, err := roles.New(cfg, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "jwt_authenticated", roleFor(p, token256))
+	assert.Equal(t, "jwt_authenticated", roleFor(p, token384))
+
+	cfg.JWT.AllowedAlgs = []string{"ES384"}
+	p, err = roles.New(cfg, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "guest", roleFor(p, token256))
+	assert.Equal(t, "jwt_authenticated", roleFor(p, token384))
+}
@@ -0,0 +1,96 @@
+package roles
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/effective-security/porto/x/slices"
+	"github.com/effective-security/xpki/jwt"
+	"github.com/pkg/errors"
+)
+
+// jwtMapper provides JWT identities for the identity map,
+// that is applied to the requests with the path prefix
+type jwtMapper struct {
+	prefix string
+	config JWTIdentityMap
+	roles  map[string][]string
+
+	parser        jwt.Parser
+	introspector  *introspector
+	tenantPattern *regexp.Regexp
+}
+
+// newJWTMapper returns the mapper for the identity map,
+// the defaults are applied if the map is enabled
+func newJWTMapper(prefix string, m *JWTIdentityMap, parser jwt.Parser, skew time.Duration) (*jwtMapper, error) {
+	mapper := &jwtMapper{
+		prefix: prefix,
+		config: *m,
+		parser: parser,
+	}
+	if !m.Enabled {
+		return mapper, nil
+	}
+
+	cfg := &mapper.config
+	cfg.Issuers = mergeValues(m.Issuer, m.Issuers)
+	cfg.Audiences = mergeValues(m.Audience, m.Audiences)
+	cfg.SubjectClaim = slices.StringsCoalesce(m.SubjectClaim, DefaultSubjectClaim)
+	cfg.RoleClaim = slices.StringsCoalesce(m.RoleClaim, DefaultRoleClaim)
+	cfg.TenantClaim = slices.StringsCoalesce(m.TenantClaim, DefaultTenantClaim)
+	cfg.IPClaim = slices.StringsCoalesce(m.IPClaim, DefaultIPClaim)
+	if m.JWKSURL != "" {
+		mapper.parser = newJWKSCache(m.JWKSURL, m.JWKSCacheTTL, skew)
+	}
+	if m.IntrospectionURL != "" {
+		mapper.introspector = newIntrospector(m)
+	}
+	if m.TenantFromSubjectPattern != "" {
+		re, err := regexp.Compile(m.TenantFromSubjectPattern)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid JWT tenant pattern")
+		}
+		mapper.tenantPattern = re
+	}
+
+	mapper.roles = mapRoles(m.Roles, m.NormalizeClaims)
+	return mapper, nil
+}
+
+// newJWTPathMappers returns the mappers for the path scoped identity maps,
+// sorted by the longest prefix first
+func newJWTPathMappers(paths []JWTPathIdentityMap, parser jwt.Parser, skew time.Duration) ([]*jwtMapper, error) {
+	var mappers []*jwtMapper
+	for i := range paths {
+		pm := &paths[i]
+		if pm.PathPrefix == "" {
+			return nil, errors.Errorf("JWT path prefix is required")
+		}
+		m, err := newJWTMapper(pm.PathPrefix, &pm.JWT, parser, skew)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid JWT path %s", pm.PathPrefix)
+		}
+		mappers = append(mappers, m)
+	}
+	sort.SliceStable(mappers, func(i, j int) bool {
+		return len(mappers[i].prefix) > len(mappers[j].prefix)
+	})
+	return mappers, nil
+}
+
+// jwtForRequest returns the mapper with the longest path prefix
+// matching the request, or the base mapper if none matches
+func (p *provider) jwtForRequest(r *http.Request) *jwtMapper {
+	if len(p.jwtPaths) > 0 && r.URL != nil {
+		for _, m := range p.jwtPaths {
+			if strings.HasPrefix(r.URL.Path, m.prefix) {
+				return m
+			}
+		}
+	}
+	return p.jwt
+}
@@ -0,0 +1,89 @@
+package roles
+
+import (
+	"context"
+
+	"github.com/effective-security/porto/xhttp/identity"
+	"github.com/effective-security/xpki/jwt"
+)
+
+// RoleResolver returns the role for the verified token claims.
+// If the returned role is empty, then DefaultAuthenticatedRole is used
+type RoleResolver func(claims jwt.MapClaims) (role string, err error)
+
+// IdentityHook is called for the resolved identity before it's returned,
+// and allows to augment the identity with additional roles or tenant.
+// If the hook returns an error, then the resolved identity is used
+type IdentityHook func(ctx context.Context, id identity.Identity) (identity.Identity, error)
+
+// AccessTokenMatcher returns true if the token must be resolved
+// by AccessToken provider, instead of the JWT parser
+type AccessTokenMatcher func(token string) bool
+
+// Option is an option that can be passed to New().
+// Option configures how we set up the provider
+type Option interface {
+	apply(*options)
+}
+
+// WithRoleResolver option to provide a custom role resolver,
+// that overrides the static Roles mapping for JWT and DPoP tokens
+func WithRoleResolver(resolver RoleResolver) Option {
+	return newFuncOption(func(o *options) {
+		o.roleResolver = resolver
+	})
+}
+
+// WithAccessTokenMatcher option to provide a matcher for the access tokens,
+// that takes precedence over IdentityMap.AccessTokenPrefix
+func WithAccessTokenMatcher(matcher AccessTokenMatcher) Option {
+	return newFuncOption(func(o *options) {
+		o.accessTokenMatcher = matcher
+	})
+}
+
+// WithDPoPReplayStore option to provide a store for DPoP proofs replay protection,
+// by default the in-memory store is used
+func WithDPoPReplayStore(store DPoPReplayStore) Option {
+	return newFuncOption(func(o *options) {
+		o.dpopReplay = store
+	})
+}
+
+// WithIdentityHook option to provide a hook,
+// that is called for the authenticated identities
+func WithIdentityHook(hook IdentityHook) Option {
+	return newFuncOption(func(o *options) {
+		o.identityHook = hook
+	})
+}
+
+// WithAuditSink option to provide a sink for the authentication decisions,
+// by default the decisions are not audited
+func WithAuditSink(sink AuditSink) Option {
+	return newFuncOption(func(o *options) {
+		o.auditSink = sink
+	})
+}
+
+type options struct {
+	roleResolver       RoleResolver
+	accessTokenMatcher AccessTokenMatcher
+	dpopReplay         DPoPReplayStore
+	identityHook       IdentityHook
+	auditSink          AuditSink
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (fo *funcOption) apply(o *options) {
+	fo.f(o)
+}
+
+func newFuncOption(f func(*options)) *funcOption {
+	return &funcOption{
+		f: f,
+	}
+}
@@ -3,9 +3,12 @@ package roles
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
+	"time"
 
 	tcredentials "github.com/effective-security/porto/gserver/credentials"
 	"github.com/effective-security/porto/x/slices"
@@ -43,6 +46,20 @@ const (
 
 	// DefaultTenantClaim defines default Tenant claim
 	DefaultTenantClaim = "tenant"
+
+	// DefaultIPClaim defines default claim with the client IP
+	DefaultIPClaim = "ip"
+
+	// DefaultClockSkew defines default leeway for the time based claims
+	// of the tokens and DPoP proofs
+	DefaultClockSkew = 30 * time.Second
+
+	// TLSMatchURI specifies to match TLS identity by SPIFFE URI
+	TLSMatchURI = "uri"
+	// TLSMatchDNS specifies to match TLS identity by DNS SAN
+	TLSMatchDNS = "dns"
+	// TLSMatchCN specifies to match TLS identity by Subject Common Name
+	TLSMatchCN = "cn"
 )
 
 // IdentityProvider interface to extract identity from requests
@@ -51,6 +68,11 @@ type IdentityProvider interface {
 	ApplicableForRequest(*http.Request) bool
 	// IdentityFromRequest returns identity from the request
 	IdentityFromRequest(*http.Request) (identity.Identity, error)
+	// ApplicableProviders returns the authentication methods,
+	// that are enabled and have the credentials in the request,
+	// in the order they are attempted by IdentityFromRequest.
+	// It's intended for diagnostics of the requests resolved to guest
+	ApplicableProviders(*http.Request) []string
 
 	// ApplicableForContext returns true if the provider is applicable for the request
 	ApplicableForContext(ctx context.Context) bool
@@ -68,51 +90,132 @@ type AccessToken interface {
 // Provider for identity
 type provider struct {
 	config    IdentityMap
-	dpopRoles map[string]string
-	jwtRoles  map[string]string
-	tlsRoles  map[string]string
-	jwt       jwt.Parser
-	at        AccessToken
+	dpopRoles map[string][]string
+	tlsRoles  map[string][]string
+	// tlsPatterns are SPIFFE ID patterns from tlsRoles
+	tlsPatterns []spiffePattern
+	basicRoles  map[string][]string
+	apiKeys     []apiKeyEntry
+
+	denySubjects  map[string]bool
+	allowSubjects map[string]bool
+
+	cache *identityCache
+	at    AccessToken
+
+	dpopParser        jwt.Parser
+	dpopIntrospector  *introspector
+	dpopTenantPattern *regexp.Regexp
+
+	// jwt is the base JWT mapper
+	jwt *jwtMapper
+	// jwtPaths are the path scoped JWT mappers
+	jwtPaths []*jwtMapper
+
+	opts options
 }
 
 // New returns Authz provider instance
-func New(config *IdentityMap, jwt jwt.Parser, at AccessToken) (IdentityProvider, error) {
+func New(config *IdentityMap, jwt jwt.Parser, at AccessToken, ops ...Option) (IdentityProvider, error) {
 	prov := &provider{
-		config:    *config,
-		dpopRoles: make(map[string]string),
-		jwtRoles:  make(map[string]string),
-		tlsRoles:  make(map[string]string),
-		jwt:       jwt,
-		at:        at,
+		config: *config,
+		at:     at,
+
+		dpopParser: jwt,
+	}
+	for _, op := range ops {
+		op.apply(&prov.opts)
+	}
+
+	prov.config.AnonymousRole = slices.StringsCoalesce(config.AnonymousRole, identity.GuestRoleName)
+	if config.ClockSkew == 0 {
+		prov.config.ClockSkew = DefaultClockSkew
+	} else if config.ClockSkew < 0 {
+		prov.config.ClockSkew = 0
+	}
+	if config.DPoP.Enabled && prov.opts.dpopReplay == nil {
+		prov.opts.dpopReplay = NewDPoPReplayStore()
+	}
+	if len(config.TokenMetadataKeys) > 0 {
+		prov.config.TokenMetadataKeys = make([]string, len(config.TokenMetadataKeys))
+		for i, key := range config.TokenMetadataKeys {
+			prov.config.TokenMetadataKeys[i] = strings.ToLower(key)
+		}
+	} else {
+		prov.config.TokenMetadataKeys = []string{tcredentials.TokenFieldNameGRPC}
+	}
+	if config.TokenCacheSize > 0 {
+		prov.cache = newIdentityCache(config.TokenCacheSize)
+	}
+	if len(config.DenySubjects) > 0 {
+		prov.denySubjects = make(map[string]bool, len(config.DenySubjects))
+		for _, subj := range config.DenySubjects {
+			prov.denySubjects[subj] = true
+		}
+	}
+	if len(config.AllowSubjects) > 0 {
+		prov.allowSubjects = make(map[string]bool, len(config.AllowSubjects))
+		for _, subj := range config.AllowSubjects {
+			prov.allowSubjects[subj] = true
+		}
 	}
 
 	if config.DPoP.Enabled {
+		prov.config.DPoP.Issuers = mergeValues(config.DPoP.Issuer, config.DPoP.Issuers)
+		prov.config.DPoP.Audiences = mergeValues(config.DPoP.Audience, config.DPoP.Audiences)
 		prov.config.DPoP.SubjectClaim = slices.StringsCoalesce(prov.config.DPoP.SubjectClaim, DefaultSubjectClaim)
 		prov.config.DPoP.RoleClaim = slices.StringsCoalesce(prov.config.DPoP.RoleClaim, DefaultRoleClaim)
 		prov.config.DPoP.TenantClaim = slices.StringsCoalesce(prov.config.DPoP.TenantClaim, DefaultTenantClaim)
-
-		for role, users := range config.DPoP.Roles {
-			for _, user := range users {
-				prov.dpopRoles[user] = role
+		prov.config.DPoP.IPClaim = slices.StringsCoalesce(prov.config.DPoP.IPClaim, DefaultIPClaim)
+		if config.DPoP.JWKSURL != "" {
+			prov.dpopParser = newJWKSCache(config.DPoP.JWKSURL, config.DPoP.JWKSCacheTTL, prov.config.ClockSkew)
+		}
+		if config.DPoP.IntrospectionURL != "" {
+			prov.dpopIntrospector = newIntrospector(&config.DPoP)
+		}
+		if config.DPoP.TenantFromSubjectPattern != "" {
+			re, err := regexp.Compile(config.DPoP.TenantFromSubjectPattern)
+			if err != nil {
+				return nil, errors.WithMessage(err, "invalid DPoP tenant pattern")
 			}
+			prov.dpopTenantPattern = re
 		}
+
+		prov.dpopRoles = mapRoles(config.DPoP.Roles, config.DPoP.NormalizeClaims)
+	}
+	base, err := newJWTMapper("", &config.JWT, jwt, prov.config.ClockSkew)
+	if err != nil {
+		return nil, err
 	}
-	if config.JWT.Enabled {
-		prov.config.JWT.SubjectClaim = slices.StringsCoalesce(prov.config.JWT.SubjectClaim, DefaultSubjectClaim)
-		prov.config.JWT.RoleClaim = slices.StringsCoalesce(prov.config.JWT.RoleClaim, DefaultRoleClaim)
-		prov.config.JWT.TenantClaim = slices.StringsCoalesce(prov.config.JWT.TenantClaim, DefaultTenantClaim)
+	prov.jwt = base
+	prov.config.JWT = base.config
 
-		for role, users := range config.JWT.Roles {
-			for _, user := range users {
-				prov.jwtRoles[user] = role
-			}
-		}
+	prov.jwtPaths, err = newJWTPathMappers(config.JWTPaths, jwt, prov.config.ClockSkew)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.APIKey.Enabled {
+		prov.config.APIKey.HeaderName = slices.StringsCoalesce(prov.config.APIKey.HeaderName, header.XAPIKey)
+		prov.apiKeys = newAPIKeyEntries(config.APIKey.Keys)
+	}
+	if config.Basic.Enabled {
+		prov.basicRoles = mapRoles(config.Basic.Roles, false)
 	}
 	if config.TLS.Enabled {
-		for role, users := range config.TLS.Roles {
-			for _, user := range users {
-				prov.tlsRoles[user] = role
+		prov.config.TLS.MatchField = strings.ToLower(slices.StringsCoalesce(config.TLS.MatchField, TLSMatchURI))
+		switch prov.config.TLS.MatchField {
+		case TLSMatchURI, TLSMatchDNS, TLSMatchCN:
+		default:
+			return nil, errors.Errorf("unsupported TLS match field: %s", config.TLS.MatchField)
+		}
+		prov.tlsRoles = mapRoles(config.TLS.Roles, false)
+		if prov.config.TLS.MatchField == TLSMatchURI {
+			patterns, err := newSPIFFEPatterns(prov.tlsRoles)
+			if err != nil {
+				return nil, err
 			}
+			prov.tlsPatterns = patterns
 		}
 	}
 
@@ -121,10 +224,17 @@ func New(config *IdentityMap, jwt jwt.Parser, at AccessToken) (IdentityProvider,
 
 // ApplicableForRequest returns true if the provider is applicable for the request
 func (p *provider) ApplicableForRequest(r *http.Request) bool {
-	if (p.config.DPoP.Enabled || p.config.JWT.Enabled) &&
+	jm := p.jwtForRequest(r)
+	if (p.config.DPoP.Enabled || jm.config.Enabled || p.config.Basic.Enabled) &&
 		r.Header.Get(header.Authorization) != "" {
 		return true
 	}
+	if jm.config.Enabled && jm.tokenFromCookie(r) != "" {
+		return true
+	}
+	if p.config.APIKey.Enabled && r.Header.Get(p.config.APIKey.HeaderName) != "" {
+		return true
+	}
 	if p.config.TLS.Enabled && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
 		return true
 	}
@@ -132,12 +242,43 @@ func (p *provider) ApplicableForRequest(r *http.Request) bool {
 	return false
 }
 
+// ApplicableProviders returns the authentication methods,
+// that are enabled and have the credentials in the request
+func (p *provider) ApplicableProviders(r *http.Request) []string {
+	var methods []string
+
+	jm := p.jwtForRequest(r)
+	authHeader := r.Header.Get(header.Authorization)
+	_, typ := tokenType(authHeader)
+	if p.config.DPoP.Enabled && strings.EqualFold(typ, "DPoP") {
+		methods = append(methods, AuthMethodDPoP)
+	}
+	if jm.config.Enabled &&
+		(strings.EqualFold(typ, "Bearer") || (authHeader == "" && jm.tokenFromCookie(r) != "")) {
+		methods = append(methods, AuthMethodJWT)
+	}
+	if p.config.Basic.Enabled && strings.EqualFold(typ, BasicTokenType) {
+		methods = append(methods, AuthMethodBasic)
+	}
+	if p.config.APIKey.Enabled && r.Header.Get(p.config.APIKey.HeaderName) != "" {
+		methods = append(methods, AuthMethodAPIKey)
+	}
+	if p.config.TLS.Enabled && getPeerCertAndCount(r) > 0 {
+		methods = append(methods, AuthMethodTLS)
+	}
+	return methods
+}
+
 // ApplicableForContext returns true if the provider is applicable for context
 func (p *provider) ApplicableForContext(ctx context.Context) bool {
 	md, ok := metadata.FromIncomingContext(ctx)
-	authorization := ok && len(md["authorization"]) > 0
+	authorization := ok && p.tokenFromMetadata(md) != ""
 
-	if authorization && (p.config.DPoP.Enabled || p.config.JWT.Enabled) {
+	if authorization && (p.config.DPoP.Enabled || p.config.JWT.Enabled || p.config.Basic.Enabled) {
+		return true
+	}
+
+	if p.config.APIKey.Enabled && ok && len(md[strings.ToLower(p.config.APIKey.HeaderName)]) > 0 {
 		return true
 	}
 
@@ -154,6 +295,33 @@ func (p *provider) ApplicableForContext(ctx context.Context) bool {
 	return false
 }
 
+// isAccessToken returns true if the token must be resolved
+// by AccessToken provider. The matcher option takes precedence over
+// AccessTokenPrefix, if none is configured then any token is tried
+func (p *provider) isAccessToken(auth string) bool {
+	if p.at == nil {
+		return false
+	}
+	if p.opts.accessTokenMatcher != nil {
+		return p.opts.accessTokenMatcher(auth)
+	}
+	if p.config.AccessTokenPrefix != "" {
+		return strings.HasPrefix(auth, p.config.AccessTokenPrefix)
+	}
+	return true
+}
+
+// tokenFromMetadata returns the token from the first
+// configured metadata key that is present
+func (p *provider) tokenFromMetadata(md metadata.MD) string {
+	for _, key := range p.config.TokenMetadataKeys {
+		if vals := md[key]; len(vals) > 0 && vals[0] != "" {
+			return vals[0]
+		}
+	}
+	return ""
+}
+
 func tokenType(auth string) (token string, tokenType string) {
 	if auth == "" {
 		return
@@ -171,6 +339,18 @@ func tokenType(auth string) (token string, tokenType string) {
 
 // IdentityFromRequest returns identity from the request
 func (p *provider) IdentityFromRequest(r *http.Request) (identity.Identity, error) {
+	event := &AuditEvent{}
+	id, err := p.identityFromRequest(r, event)
+	if err != nil {
+		p.audit(r.Context(), event, nil, err)
+		return nil, err
+	}
+	id = p.applyHook(r.Context(), id)
+	p.audit(r.Context(), event, id, nil)
+	return id, nil
+}
+
+func (p *provider) identityFromRequest(r *http.Request, event *AuditEvent) (identity.Identity, error) {
 	peers := getPeerCertAndCount(r)
 	// logger.ContextKV(r.Context(), xlog.DEBUG,
 	// 	"dpop_enabled", p.config.DPoP.Enabled,
@@ -178,8 +358,14 @@ func (p *provider) IdentityFromRequest(r *http.Request) (identity.Identity, erro
 	// 	"tls_enabled", p.config.TLS.Enabled,
 	// 	"certs_present", peers)
 
+	jm := p.jwtForRequest(r)
 	authHeader := r.Header.Get(header.Authorization)
 	token, typ := tokenType(authHeader)
+	if authHeader == "" && jm.config.Enabled {
+		if token = jm.tokenFromCookie(r); token != "" {
+			typ = "Bearer"
+		}
+	}
 
 	var err error
 	var id identity.Identity
@@ -194,7 +380,8 @@ func (p *provider) IdentityFromRequest(r *http.Request) (identity.Identity, erro
 				Path:   u.Path,
 			}
 
-			id, err = p.dpopIdentity(r.Context(), phdr, r.Method, coreURL.String(), token, "DPoP")
+			event.Method = AuthMethodDPoP
+			id, err = p.dpopIdentity(r.Context(), phdr, r.Method, coreURL.String(), token, "DPoP", clientIP(r))
 			if err != nil {
 				logger.ContextKV(r.Context(), xlog.TRACE, "token", token, "err", err.Error())
 				//return nil, err
@@ -204,9 +391,10 @@ func (p *provider) IdentityFromRequest(r *http.Request) (identity.Identity, erro
 		}
 	}
 
-	if p.config.JWT.Enabled {
+	if jm.config.Enabled {
 		if strings.EqualFold(typ, "Bearer") {
-			id, err = p.jwtIdentity(token, "Bearer")
+			event.Method = AuthMethodJWT
+			id, err = p.jwtIdentity(r.Context(), jm, token, "Bearer", peerCertificate(r.TLS), clientIP(r))
 			if err != nil {
 				logger.ContextKV(r.Context(), xlog.TRACE, "token", token, "err", err.Error())
 				//return nil, err
@@ -216,7 +404,32 @@ func (p *provider) IdentityFromRequest(r *http.Request) (identity.Identity, erro
 		}
 	}
 
+	if p.config.Basic.Enabled {
+		if strings.EqualFold(typ, BasicTokenType) {
+			event.Method = AuthMethodBasic
+			id, err = p.basicIdentity(token)
+			if err != nil {
+				logger.ContextKV(r.Context(), xlog.TRACE, "reason", "basic", "err", err.Error())
+			} else {
+				return id, nil
+			}
+		}
+	}
+
+	if p.config.APIKey.Enabled {
+		if key := r.Header.Get(p.config.APIKey.HeaderName); key != "" {
+			event.Method = AuthMethodAPIKey
+			id, err = p.apiKeyIdentity(key)
+			if err != nil {
+				logger.ContextKV(r.Context(), xlog.TRACE, "reason", "api_key", "err", err.Error())
+			} else {
+				return id, nil
+			}
+		}
+	}
+
 	if p.config.TLS.Enabled && peers > 0 {
+		event.Method = AuthMethodTLS
 		id, err = p.tlsIdentity(r.TLS)
 		if err != nil {
 			logger.ContextKV(r.Context(), xlog.TRACE, "reason", "tls", "err", err.Error())
@@ -225,9 +438,39 @@ func (p *provider) IdentityFromRequest(r *http.Request) (identity.Identity, erro
 		}
 	}
 
+	if err != nil && p.config.StrictMode {
+		return nil, &invalidTokenError{reason: err}
+	}
+
 	// if none of mappers are applicable or configured,
 	// then use default guest mapper
-	return identity.GuestIdentityMapper(r)
+	event.Method = AuthMethodGuest
+	if err != nil {
+		event.Reason = err.Error()
+	}
+	return p.guestIdentity(r)
+}
+
+// guestIdentity returns the anonymous identity for the request,
+// the subject is the peer's CN if available
+func (p *provider) guestIdentity(r *http.Request) (identity.Identity, error) {
+	id, err := identity.GuestIdentityMapper(r)
+	if err != nil || p.config.AnonymousRole == id.Role() {
+		return id, err
+	}
+	return identity.NewIdentity(p.config.AnonymousRole, id.Subject(), "", nil, "", ""), nil
+}
+
+// tokenFromCookie returns the token from the configured cookie
+func (m *jwtMapper) tokenFromCookie(r *http.Request) string {
+	if m.config.CookieName == "" {
+		return ""
+	}
+	c, err := r.Cookie(m.config.CookieName)
+	if err != nil {
+		return ""
+	}
+	return c.Value
 }
 
 func getPeerCertAndCount(r *http.Request) int {
@@ -249,9 +492,45 @@ func dumpDM(md metadata.MD) []any {
 
 // IdentityFromContext returns identity from context
 func (p *provider) IdentityFromContext(ctx context.Context, uri string) (identity.Identity, error) {
+	event := &AuditEvent{}
+	id, err := p.identityFromContext(ctx, uri, event)
+	if err != nil {
+		p.audit(ctx, event, nil, err)
+		return nil, err
+	}
+	id = p.applyHook(ctx, id)
+	p.audit(ctx, event, id, nil)
+	return id, nil
+}
+
+// applyHook returns the identity augmented by the hook,
+// the guest identity is not augmented
+func (p *provider) applyHook(ctx context.Context, id identity.Identity) identity.Identity {
+	if p.opts.identityHook == nil || id.Role() == p.config.AnonymousRole {
+		return id
+	}
+	aid, err := p.opts.identityHook(ctx, id)
+	if err != nil {
+		logger.ContextKV(ctx, xlog.ERROR, "reason", "identity_hook", "subject", id.Subject(), "err", err.Error())
+		return id
+	}
+	if aid == nil {
+		return id
+	}
+	return aid
+}
+
+func (p *provider) identityFromContext(ctx context.Context, uri string, event *AuditEvent) (identity.Identity, error) {
+	var err error
+	var id identity.Identity
+
 	md, ok := metadata.FromIncomingContext(ctx)
-	if ok && len(md[tcredentials.TokenFieldNameGRPC]) > 0 {
-		token, typ := tokenType(md[tcredentials.TokenFieldNameGRPC][0])
+	auth := ""
+	if ok {
+		auth = p.tokenFromMetadata(md)
+	}
+	if auth != "" {
+		token, typ := tokenType(auth)
 
 		if p.config.DebugLogs {
 			logger.ContextKV(ctx, xlog.DEBUG,
@@ -264,14 +543,25 @@ func (p *provider) IdentityFromContext(ctx context.Context, uri string) (identit
 		dhdr := md["dpop"]
 		if p.config.DPoP.Enabled &&
 			strings.EqualFold(typ, "DPoP") && len(dhdr) > 0 {
-			id, err := p.dpopIdentity(ctx, dhdr[0], "POST", uri, token, "DPoP")
+			event.Method = AuthMethodDPoP
+			id, err = p.dpopIdentity(ctx, dhdr[0], "POST", uri, token, "DPoP", clientIPFromContext(ctx))
 			if err == nil {
 				return id, nil
 			}
 		}
 
-		if p.config.JWT.Enabled && typ != "" {
-			id, err := p.jwtIdentity(token, typ)
+		if p.config.Basic.Enabled && strings.EqualFold(typ, BasicTokenType) {
+			event.Method = AuthMethodBasic
+			id, err = p.basicIdentity(token)
+			if err == nil {
+				return id, nil
+			}
+			logger.ContextKV(ctx, xlog.TRACE, "reason", "basic", "err", err.Error())
+		}
+
+		if p.config.JWT.Enabled && typ != "" && !strings.EqualFold(typ, BasicTokenType) {
+			event.Method = AuthMethodJWT
+			id, err = p.jwtIdentity(ctx, p.jwt, token, typ, peerCertificateFromContext(ctx), clientIPFromContext(ctx))
 			if err == nil {
 				return id, nil
 			}
@@ -281,12 +571,24 @@ func (p *provider) IdentityFromContext(ctx context.Context, uri string) (identit
 		logger.ContextKV(ctx, xlog.DEBUG, "reason", "no_metadata_incoming")
 	}
 
+	if p.config.APIKey.Enabled && ok {
+		if keys := md[strings.ToLower(p.config.APIKey.HeaderName)]; len(keys) > 0 {
+			event.Method = AuthMethodAPIKey
+			id, err = p.apiKeyIdentity(keys[0])
+			if err == nil {
+				return id, nil
+			}
+			logger.ContextKV(ctx, xlog.TRACE, "reason", "api_key", "err", err.Error())
+		}
+	}
+
 	if p.config.TLS.Enabled {
 		c, ok := peer.FromContext(ctx)
 		if ok {
 			si, ok := c.AuthInfo.(credentials.TLSInfo)
 			if ok && len(si.State.PeerCertificates) > 0 {
-				id, err := p.tlsIdentity(&si.State)
+				event.Method = AuthMethodTLS
+				id, err = p.tlsIdentity(&si.State)
 				if err == nil {
 					logger.ContextKV(ctx, xlog.DEBUG, "type", "TLS", "role", id)
 					return id, nil
@@ -294,40 +596,63 @@ func (p *provider) IdentityFromContext(ctx context.Context, uri string) (identit
 			}
 		}
 	}
+	if err != nil && p.config.StrictMode {
+		return nil, &invalidTokenError{reason: err}
+	}
 	if p.config.DebugLogs {
-		logger.ContextKV(ctx, xlog.DEBUG, "role", "guest")
+		logger.ContextKV(ctx, xlog.DEBUG, "role", p.config.AnonymousRole)
+	}
+	event.Method = AuthMethodGuest
+	if err != nil {
+		event.Reason = err.Error()
 	}
-	return identity.GuestIdentityForContext(ctx, uri)
+	return identity.NewIdentity(p.config.AnonymousRole, "", "", nil, "", ""), nil
 }
 
-func (p *provider) dpopIdentity(ctx context.Context, phdr, method, uri string, auth, tokenType string) (identity.Identity, error) {
-	res, err := dpop.VerifyClaims(dpop.VerifyConfig{}, phdr, method, uri)
+func (p *provider) dpopIdentity(ctx context.Context, phdr, method, uri string, auth, tokenType, clientIP string) (identity.Identity, error) {
+	res, err := verifyDPoPProof(phdr, method, uri, p.config.ClockSkew)
 	if err != nil {
 		return nil, err
 	}
 
 	var claims jwt.MapClaims
-	cfg := jwt.VerifyConfig{
-		ExpectedIssuer: p.config.DPoP.Issuer,
-	}
-	if p.config.DPoP.Audience != "" {
-		cfg.ExpectedAudience = []string{p.config.DPoP.Audience}
-	}
-	if p.at != nil {
+	cfg := verifyConfig(&p.config.DPoP)
+	if p.isAccessToken(auth) {
 		claims, err = p.at.Claims(ctx, auth)
 		if err != nil {
 			return nil, err
 		}
 		if claims != nil {
-			err = claims.Valid(cfg)
+			err = validateClaims(claims, cfg, p.config.ClockSkew)
 		}
 	}
+	if claims == nil && p.dpopIntrospector != nil && !isJWT(auth) {
+		claims, err = p.dpopIntrospector.Claims(ctx, auth)
+		if err != nil {
+			return nil, err
+		}
+		err = validateClaims(claims, cfg, p.config.ClockSkew)
+	}
 	if claims == nil {
-		claims, err = p.jwt.ParseToken(auth, cfg)
+		if err = verifyAlg(&p.config.DPoP, auth); err != nil {
+			return nil, err
+		}
+		claims, err = parseToken(p.dpopParser, auth, cfg, p.config.ClockSkew)
 	}
 	if err != nil {
 		return nil, err
 	}
+	if err = verifyIssuer(&p.config.DPoP, claims); err != nil {
+		return nil, err
+	}
+	if err = verifyAudience(&p.config.DPoP, claims); err != nil {
+		return nil, err
+	}
+	if p.config.DPoP.RequireIPBinding {
+		if err = verifyIPBinding(claims, p.config.DPoP.IPClaim, clientIP); err != nil {
+			return nil, err
+		}
+	}
 
 	tb, err := dpop.GetCnfClaim(claims)
 	if err != nil {
@@ -337,91 +662,281 @@ func (p *provider) dpopIdentity(ctx context.Context, phdr, method, uri string, a
 		logger.ContextKV(ctx, xlog.DEBUG, "header", tb, "claims", res.Thumbprint)
 		return nil, errors.Errorf("dpop: thumbprint mismatch")
 	}
+	exp := res.Claims.IssuedAt.Time().Add(dpop.DefaultExpiration)
+	if p.opts.dpopReplay.Seen(res.Thumbprint+":"+res.Claims.ID, exp) {
+		return nil, errors.Errorf("dpop: proof replay: %s", res.Claims.ID)
+	}
 
 	email := claims.String("email")
-	subj := claims.String(p.config.DPoP.SubjectClaim)
-	tenant := claims.String(p.config.DPoP.TenantClaim)
-	roleClaim := claims.String(p.config.DPoP.RoleClaim)
-	role := p.dpopRoles[roleClaim]
-	if role == "" {
-		role = p.config.DPoP.DefaultAuthenticatedRole
+	subj := claimString(claims, p.config.DPoP.SubjectClaim)
+	tenant := claimTenant(claims, p.config.DPoP.TenantClaim, subj, p.dpopTenantPattern)
+	scopes := claimScopes(claims, p.config.DPoP.ScopeClaim)
+	roles, err := p.resolveRoles(p.dpopRoles, claims, &p.config.DPoP)
+	if err != nil {
+		return nil, err
 	}
+	roles = defaultRoles(roles, p.config.DPoP.DefaultAuthenticatedRole)
 	logger.ContextKV(ctx, xlog.DEBUG,
-		"role", role,
+		"roles", roles,
 		"tenant", tenant,
 		"subject", subj,
 		"email", email,
 		"type", tokenType)
-	return identity.NewIdentity(role, subj, tenant, claims, auth, tokenType), nil
+	if err = p.checkSubject(subj); err != nil {
+		return nil, err
+	}
+	return identity.NewIdentityWithRoles(roles, subj, tenant, claims, auth, tokenType, scopes), nil
 }
 
-func (p *provider) jwtIdentity(auth, tokenType string) (identity.Identity, error) {
+func (p *provider) jwtIdentity(ctx context.Context, m *jwtMapper, auth, tokenType string, peerCert *x509.Certificate, clientIP string) (identity.Identity, error) {
+	var thumbprint string
+	if m.config.RequireCertBinding {
+		thumbprint = certThumbprint(peerCert)
+	}
+	var boundIP string
+	if m.config.RequireIPBinding {
+		boundIP = clientIP
+	}
+
+	var cacheKey string
+	if p.cache != nil {
+		cacheKey = identityCacheKey(auth, m.prefix+tokenType+thumbprint+boundIP)
+		if id := p.cache.Get(cacheKey); id != nil {
+			return id, nil
+		}
+	}
+
 	var claims jwt.MapClaims
 	var err error
 
-	cfg := jwt.VerifyConfig{
-		ExpectedIssuer: p.config.JWT.Issuer,
-	}
-	if p.config.JWT.Audience != "" {
-		cfg.ExpectedAudience = []string{p.config.JWT.Audience}
-	}
-	if p.at != nil {
-		claims, err = p.at.Claims(context.Background(), auth)
+	cfg := verifyConfig(&m.config)
+	if p.isAccessToken(auth) {
+		claims, err = p.at.Claims(ctx, auth)
 		if err != nil {
 			return nil, errors.WithMessage(err, "unable to extract claims from access token")
 		}
 		if claims != nil {
-			err = claims.Valid(cfg)
+			err = validateClaims(claims, cfg, p.config.ClockSkew)
 			if err != nil {
 				return nil, err
 			}
 		}
 	}
+	introspected := false
+	if claims == nil && m.introspector != nil && !isJWT(auth) {
+		introspected = true
+		claims, err = m.introspector.Claims(ctx, auth)
+		if err != nil {
+			return nil, errors.WithMessage(err, "unable to introspect token")
+		}
+		if err = validateClaims(claims, cfg, p.config.ClockSkew); err != nil {
+			return nil, err
+		}
+	}
 	if claims == nil {
-		claims, err = p.jwt.ParseToken(auth, cfg)
+		if err = verifyAlg(&m.config, auth); err != nil {
+			return nil, err
+		}
+		claims, err = parseToken(m.parser, auth, cfg, p.config.ClockSkew)
 		if err != nil {
 			return nil, errors.WithMessage(err, "unable to parse JWT token")
 		}
 	}
+	if err = verifyIssuer(&m.config, claims); err != nil {
+		return nil, err
+	}
+	if err = verifyAudience(&m.config, claims); err != nil {
+		return nil, err
+	}
+	if m.config.RequireCertBinding {
+		if err = verifyCertBinding(claims, thumbprint); err != nil {
+			return nil, err
+		}
+	}
+	if m.config.RequireIPBinding {
+		if err = verifyIPBinding(claims, m.config.IPClaim, boundIP); err != nil {
+			return nil, err
+		}
+	}
 
 	email := claims.String("email")
-	subj := claims.String(p.config.JWT.SubjectClaim)
-	tenant := claims.String(p.config.JWT.TenantClaim)
-	roleClaim := claims.String(p.config.JWT.RoleClaim)
-	role := p.jwtRoles[roleClaim]
-	if role == "" {
-		role = p.config.JWT.DefaultAuthenticatedRole
+	subj := claimString(claims, m.config.SubjectClaim)
+	tenant := claimTenant(claims, m.config.TenantClaim, subj, m.tenantPattern)
+	scopes := claimScopes(claims, m.config.ScopeClaim)
+	roles, err := p.resolveRoles(m.roles, claims, &m.config)
+	if err != nil {
+		return nil, err
 	}
+	roles = defaultRoles(roles, m.config.DefaultAuthenticatedRole)
 	logger.KV(xlog.DEBUG,
-		"role", role,
+		"roles", roles,
 		"tenant", tenant,
 		"subject", subj,
 		"email", email,
 		"type", tokenType)
-	return identity.NewIdentity(role, subj, tenant, claims, auth, tokenType), nil
+	if err = p.checkSubject(subj); err != nil {
+		return nil, err
+	}
+	id := identity.NewIdentityWithRoles(roles, subj, tenant, claims, auth, tokenType, scopes)
+	if p.cache != nil {
+		if exp := claims.Time("exp"); exp != nil {
+			expiresAt := *exp
+			// the introspected token can be revoked before it expires,
+			// keep it no longer than the introspection response
+			if introspected {
+				if ttl := time.Now().Add(m.introspector.ttl); ttl.Before(expiresAt) {
+					expiresAt = ttl
+				}
+			}
+			p.cache.Add(cacheKey, id, expiresAt)
+		}
+	}
+	return id, nil
 }
 
-func (p *provider) tlsIdentity(TLS *tls.ConnectionState) (identity.Identity, error) {
-	peer := TLS.PeerCertificates[0]
-	if len(peer.URIs) == 1 && peer.URIs[0].Scheme == "spiffe" {
-		spiffe := peer.URIs[0].String()
-		role := p.tlsRoles[spiffe]
+// resolveRoles returns the role from the custom resolver if provided,
+// or all the roles from the static roles mapping
+func (p *provider) resolveRoles(roles map[string][]string, claims jwt.MapClaims, m *JWTIdentityMap) ([]string, error) {
+	if p.opts.roleResolver != nil {
+		role, err := p.opts.roleResolver(claims)
+		if err != nil {
+			return nil, errors.WithMessage(err, "unable to resolve role")
+		}
 		if role == "" {
-			role = p.config.TLS.DefaultAuthenticatedRole
+			return nil, nil
 		}
-		logger.KV(xlog.DEBUG, "spiffe", spiffe, "role", role)
-		claims := map[string]interface{}{
-			"sub":    peer.Subject.String(),
-			"iss":    peer.Issuer.String(),
-			"spiffe": spiffe,
+		return []string{role}, nil
+	}
+	values := claimStrings(claims, m.RoleClaim)
+	if m.NormalizeClaims {
+		// the claim values must not be modified
+		normalized := make([]string, len(values))
+		for i, v := range values {
+			normalized[i] = normalizeValue(v)
 		}
-		if len(peer.EmailAddresses) > 0 {
-			claims["email"] = peer.EmailAddresses[0]
+		values = normalized
+	}
+	return findRoles(roles, values), nil
+}
+
+// checkSubject returns error if the subject is denied,
+// or not in the allowed list
+func (p *provider) checkSubject(subject string) error {
+	if p.denySubjects[subject] {
+		logger.KV(xlog.WARNING, "reason", "denied", "subject", subject)
+		return errors.Errorf("subject is denied: %s", subject)
+	}
+	if p.allowSubjects != nil && !p.allowSubjects[subject] {
+		logger.KV(xlog.DEBUG, "reason", "not_allowed", "subject", subject)
+		return errors.Errorf("subject is not allowed: %s", subject)
+	}
+	return nil
+}
+
+// mergeValues returns the list of values, including the single value
+func mergeValues(value string, values []string) []string {
+	if len(values) == 0 || value == "" {
+		return values
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return values
 		}
-		return identity.NewIdentity(role, peer.Subject.CommonName, "", claims, "", ""), nil
 	}
+	return append([]string{value}, values...)
+}
 
-	logger.KV(xlog.DEBUG, "spiffe", "none", "cn", peer.Subject.CommonName)
+// verifyConfig returns jwt.VerifyConfig for the identity map
+func verifyConfig(m *JWTIdentityMap) jwt.VerifyConfig {
+	cfg := jwt.VerifyConfig{}
+	if len(m.Issuers) == 0 {
+		cfg.ExpectedIssuer = m.Issuer
+	}
+	if len(m.Audiences) == 0 && m.Audience != "" {
+		cfg.ExpectedAudience = []string{m.Audience}
+	}
+	return cfg
+}
+
+// verifyIssuer checks the iss claim against the list of issuers,
+// or the single issuer. The issuer is compared case-sensitive,
+// as jwt.VerifyConfig ignores the case
+func verifyIssuer(m *JWTIdentityMap, claims jwt.MapClaims) error {
+	iss := claims.String("iss")
+	if len(m.Issuers) == 0 {
+		if m.Issuer != "" && iss != m.Issuer {
+			return errors.Errorf("invalid issuer: %s, expected: %s", iss, m.Issuer)
+		}
+		return nil
+	}
+	for _, expected := range m.Issuers {
+		if iss == expected {
+			return nil
+		}
+	}
+	return errors.Errorf("invalid issuer: %s, expected one of: %v", iss, m.Issuers)
+}
+
+// verifyAudience checks the aud claim against the list of audiences,
+// the token is accepted if any of its audiences matches.
+// The single audience is checked by jwt.VerifyConfig
+func verifyAudience(m *JWTIdentityMap, claims jwt.MapClaims) error {
+	if len(m.Audiences) == 0 {
+		return nil
+	}
+	aud := claimStrings(claims, "aud")
+	if len(aud) == 0 {
+		return errors.Errorf("aud claim not found")
+	}
+	for _, a := range aud {
+		if slices.ContainsString(m.Audiences, a) {
+			return nil
+		}
+	}
+	return errors.Errorf("invalid audience: %v, expected one of: %v", aud, m.Audiences)
+}
 
-	return nil, errors.Errorf("could not determine identity: %q", peer.Subject.CommonName)
+func (p *provider) tlsIdentity(TLS *tls.ConnectionState) (identity.Identity, error) {
+	peer := TLS.PeerCertificates[0]
+	claims := map[string]interface{}{
+		"sub": peer.Subject.String(),
+		"iss": peer.Issuer.String(),
+	}
+	if len(peer.EmailAddresses) > 0 {
+		claims["email"] = peer.EmailAddresses[0]
+	}
+
+	var roles []string
+	switch p.config.TLS.MatchField {
+	case TLSMatchDNS:
+		if len(peer.DNSNames) == 0 {
+			logger.KV(xlog.DEBUG, "dns", "none", "cn", peer.Subject.CommonName)
+			return nil, errors.Errorf("could not determine identity: %q", peer.Subject.CommonName)
+		}
+		roles = findRoles(p.tlsRoles, peer.DNSNames)
+		claims["dns"] = peer.DNSNames
+		logger.KV(xlog.DEBUG, "dns", peer.DNSNames, "roles", roles)
+	case TLSMatchCN:
+		if peer.Subject.CommonName == "" {
+			logger.KV(xlog.DEBUG, "cn", "none")
+			return nil, errors.Errorf("could not determine identity: %q", peer.Subject.CommonName)
+		}
+		roles = p.tlsRoles[peer.Subject.CommonName]
+		logger.KV(xlog.DEBUG, "cn", peer.Subject.CommonName, "roles", roles)
+	default:
+		if len(peer.URIs) != 1 || peer.URIs[0].Scheme != "spiffe" {
+			logger.KV(xlog.DEBUG, "spiffe", "none", "cn", peer.Subject.CommonName)
+			return nil, errors.Errorf("could not determine identity: %q", peer.Subject.CommonName)
+		}
+		spiffe := peer.URIs[0].String()
+		roles = findSPIFFERoles(p.tlsPatterns, spiffe, p.tlsRoles[spiffe])
+		claims["spiffe"] = spiffe
+		logger.KV(xlog.DEBUG, "spiffe", spiffe, "roles", roles)
+	}
+
+	roles = defaultRoles(roles, p.config.TLS.DefaultAuthenticatedRole)
+	if err := p.checkSubject(peer.Subject.CommonName); err != nil {
+		return nil, err
+	}
+	return identity.NewIdentityWithRoles(roles, peer.Subject.CommonName, "", claims, "", "", nil), nil
 }
@@ -0,0 +1,93 @@
+package roles
+
+import (
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/effective-security/porto/x/slices"
+	"github.com/pkg/errors"
+)
+
+// spiffeWildcard is the suffix of SPIFFE ID pattern in TLS Roles,
+// for example `spiffe://trusty/client/*`
+const spiffeWildcard = "/*"
+
+// spiffePattern matches SPIFFE IDs in the trust domain by the path prefix
+type spiffePattern struct {
+	trustDomain string
+	prefix      string
+	roles       []string
+}
+
+// newSPIFFEPatterns returns the patterns from the mapped TLS roles,
+// sorted by the pattern
+func newSPIFFEPatterns(roles map[string][]string) ([]spiffePattern, error) {
+	var keys []string
+	for key := range roles {
+		if strings.HasSuffix(key, spiffeWildcard) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	patterns := make([]spiffePattern, 0, len(keys))
+	for _, key := range keys {
+		u, err := parseSPIFFEID(strings.TrimSuffix(key, "*"))
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid SPIFFE pattern: %s", key)
+		}
+		patterns = append(patterns, spiffePattern{
+			trustDomain: u.Host,
+			prefix:      u.Path,
+			roles:       roles[key],
+		})
+	}
+	return patterns, nil
+}
+
+// parseSPIFFEID returns the parsed SPIFFE ID,
+// the trust domain is lowercased
+func parseSPIFFEID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if u.Scheme != "spiffe" || u.Host == "" {
+		return nil, errors.Errorf("invalid trust domain")
+	}
+	if u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.Errorf("invalid SPIFFE ID")
+	}
+	// the path must be canonical, to prevent `/client/../admin`
+	if p := strings.TrimSuffix(u.Path, "/"); p != "" && path.Clean(p) != p {
+		return nil, errors.Errorf("invalid path")
+	}
+	u.Host = strings.ToLower(u.Host)
+	return u, nil
+}
+
+// findSPIFFERoles returns the roles with the roles of the patterns
+// matching the SPIFFE ID appended, the provided roles are not modified
+func findSPIFFERoles(patterns []spiffePattern, id string, roles []string) []string {
+	if len(patterns) == 0 {
+		return roles
+	}
+	u, err := parseSPIFFEID(id)
+	if err != nil {
+		return roles
+	}
+
+	res := append([]string(nil), roles...)
+	for _, p := range patterns {
+		if u.Host == p.trustDomain && strings.HasPrefix(u.Path, p.prefix) {
+			for _, role := range p.roles {
+				if !slices.ContainsString(res, role) {
+					res = append(res, role)
+				}
+			}
+		}
+	}
+	return res
+}
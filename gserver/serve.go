@@ -352,6 +352,7 @@ func grpcServer(s *Server, tls *tls.Config, gopts ...grpc.ServerOption) *grpc.Se
 	}
 
 	chainStreamInterceptors := []grpc.StreamServerInterceptor{
+		correlation.NewStreamServerInterceptor(),
 		newStreamInterceptor(s),
 		grpc_prometheus.StreamServerInterceptor,
 	}
@@ -29,6 +29,37 @@ var (
 		RequiredTags: []string{"method", "status", "role"},
 		Help:         "provides counts for gRPC request by role.",
 	}
+	GRPCClientRetriesThrottled = metrics.Describe{
+		Name:         "rpc_client_retries_throttled",
+		Type:         metrics.TypeCounter,
+		RequiredTags: []string{"method"},
+		Help:         "provides counts for gRPC client retries throttled by the retry budget.",
+	}
+
+	TasksCount = metrics.Describe{
+		Name:         "tasks_count",
+		Type:         metrics.TypeGauge,
+		RequiredTags: []string{"scheduler"},
+		Help:         "provides the number of scheduled tasks.",
+	}
+	TasksRunning = metrics.Describe{
+		Name:         "tasks_running",
+		Type:         metrics.TypeGauge,
+		RequiredTags: []string{"scheduler"},
+		Help:         "provides the number of running tasks.",
+	}
+	TasksRuns = metrics.Describe{
+		Name:         "tasks_runs",
+		Type:         metrics.TypeGauge,
+		RequiredTags: []string{"scheduler"},
+		Help:         "provides the total number of tasks runs.",
+	}
+	TasksFailures = metrics.Describe{
+		Name:         "tasks_failures",
+		Type:         metrics.TypeGauge,
+		RequiredTags: []string{"scheduler"},
+		Help:         "provides the total number of failed tasks runs.",
+	}
 )
 
 // Metrics returns slice of metrics from this repo
@@ -38,4 +69,9 @@ var Metrics = []*metrics.Describe{
 	&GRPCReqPerf,
 	&GRPCReqPerf,
 	&GRPCReqByRole,
+	&GRPCClientRetriesThrottled,
+	&TasksCount,
+	&TasksRunning,
+	&TasksRuns,
+	&TasksFailures,
 }
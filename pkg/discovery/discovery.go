@@ -3,6 +3,8 @@ package discovery
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"sync"
 
 	"github.com/effective-security/xlog"
 	"github.com/pkg/errors"
@@ -10,27 +12,52 @@ import (
 
 var logger = xlog.NewPackageLogger("github.com/effective-security/porto/pkg", "discovery")
 
-type serviceInfo struct {
+// ServiceInfo provides information about the registered service
+type ServiceInfo struct {
 	ServerName string
-	Service    interface{}
-	Type       reflect.Type
+	// Name is the name of the service registered with RegisterNamed
+	Name    string
+	Service interface{}
+	Type    reflect.Type
 }
 
 // Discovery provides service discovery interface
 type Discovery interface {
 	Register(server string, service interface{}) error
+	// RegisterNamed registers the service with the name,
+	// the registration key is "{server}/{name}"
+	RegisterNamed(server, name string, service interface{}) error
+	Unregister(server string, service interface{}) error
+	// UnregisterNamed removes the service registered with RegisterNamed
+	UnregisterNamed(server, name string) error
 	Find(v interface{}) error
-	ForEach(v interface{}, f func(typ string) error) error
+	FindForServer(server string, v interface{}) error
+	FindAll(v interface{}) ([]interface{}, error)
+	// FindNamed returns the service registered with the name,
+	// if multiple servers registered the name,
+	// the one with the lowest registration key is returned
+	FindNamed(name string) (interface{}, error)
+	// ForEach calls f for each service implementing the interface of v,
+	// sorted by registration key. The v is used only to specify
+	// the interface type, and it's not modified.
+	// The callbacks run on a snapshot of the registrations without
+	// the registry lock held, so f can call back into the registry
+	ForEach(v interface{}, f func(key string, svc interface{}) error) error
+	// Keys returns sorted registration keys
+	Keys() []string
+	// Services returns the registered services, sorted by registration key
+	Services() []ServiceInfo
 }
 
 type disco struct {
-	reg map[string]serviceInfo
+	lock sync.RWMutex
+	reg  map[string]ServiceInfo
 }
 
 // New return new Discovery
 func New() Discovery {
 	return &disco{
-		reg: make(map[string]serviceInfo),
+		reg: make(map[string]ServiceInfo),
 	}
 }
 
@@ -39,13 +66,16 @@ func (d *disco) Register(server string, service interface{}) error {
 	typ := reflect.TypeOf(service)
 
 	logger.KV(xlog.INFO, "server", server, "type", typ)
-	key := fmt.Sprintf("%s/%s", server, typ.String())
+	key := registryKey(server, typ)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
 
 	if _, ok := d.reg[key]; ok {
 		return errors.Errorf("already registered: %s", key)
 	}
 
-	d.reg[key] = serviceInfo{
+	d.reg[key] = ServiceInfo{
 		ServerName: server,
 		Service:    service,
 		Type:       typ,
@@ -54,50 +84,249 @@ func (d *disco) Register(server string, service interface{}) error {
 	return nil
 }
 
-// Find interface
+// RegisterNamed interface
+func (d *disco) RegisterNamed(server, name string, service interface{}) error {
+	if name == "" {
+		return errors.Errorf("name is required")
+	}
+	typ := reflect.TypeOf(service)
+
+	logger.KV(xlog.INFO, "server", server, "name", name, "type", typ)
+	key := server + "/" + name
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.reg[key]; ok {
+		return errors.Errorf("already registered: %s", key)
+	}
+
+	d.reg[key] = ServiceInfo{
+		ServerName: server,
+		Name:       name,
+		Service:    service,
+		Type:       typ,
+	}
+
+	return nil
+}
+
+// Unregister interface
+func (d *disco) Unregister(server string, service interface{}) error {
+	typ := reflect.TypeOf(service)
+
+	logger.KV(xlog.INFO, "server", server, "type", typ)
+	key := registryKey(server, typ)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.reg[key]; !ok {
+		return errors.Errorf("not registered: %s", key)
+	}
+	delete(d.reg, key)
+
+	return nil
+}
+
+// UnregisterNamed interface
+func (d *disco) UnregisterNamed(server, name string) error {
+	logger.KV(xlog.INFO, "server", server, "name", name)
+	key := server + "/" + name
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if reg, ok := d.reg[key]; !ok || reg.Name != name {
+		return errors.Errorf("not registered: %s", key)
+	}
+	delete(d.reg, key)
+
+	return nil
+}
+
+// Find interface.
+// If multiple services implement the interface,
+// the one with the lowest registration key is returned,
+// where the key is "{server}/{type}"
 func (d *disco) Find(v interface{}) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
-		return errors.Errorf("a pointer to interface is required, invalid type: %v", rv)
+		return newLookupError(ErrNotPointer, "a pointer to interface is required, invalid type: %v", rv)
 	}
 
 	logger.KV(xlog.DEBUG, "type", rv.String())
 
 	rv = rv.Elem()
 	if !rv.IsValid() || rv.Kind() != reflect.Interface {
-		return errors.Errorf("non interface type: %s", reflect.TypeOf(v))
+		return newLookupError(ErrNotInterface, "non interface type: %s", reflect.TypeOf(v))
 	}
 
-	for _, reg := range d.reg {
-		if reg.Type.Implements(rv.Type()) {
-			rv.Set(reflect.ValueOf(reg.Service))
-			return nil
-		}
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	keys := d.implementedBy("", rv.Type())
+	if len(keys) > 0 {
+		return setService(rv, d.reg[keys[0]].Service)
 	}
 
-	return errors.Errorf("not implemented: " + rv.String())
+	return newLookupError(ErrNotImplemented, "not implemented: %s", rv.String())
+}
+
+// FindForServer interface,
+// only services registered by the server are considered
+func (d *disco) FindForServer(server string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return newLookupError(ErrNotPointer, "a pointer to interface is required, invalid type: %v", rv)
+	}
+
+	logger.KV(xlog.DEBUG, "server", server, "type", rv.String())
+
+	rv = rv.Elem()
+	if !rv.IsValid() || rv.Kind() != reflect.Interface {
+		return newLookupError(ErrNotInterface, "non interface type: %s", reflect.TypeOf(v))
+	}
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	keys := d.implementedBy(server, rv.Type())
+	if len(keys) > 0 {
+		return setService(rv, d.reg[keys[0]].Service)
+	}
+
+	return newLookupError(ErrNotImplemented, "not implemented by %s: %s", server, rv.String())
+}
+
+// FindAll returns all services implementing the interface,
+// sorted by the registration key
+func (d *disco) FindAll(v interface{}) ([]interface{}, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return nil, newLookupError(ErrNotPointer, "a pointer to interface is required, invalid type: %v", rv)
+	}
+
+	rv = rv.Elem()
+	if !rv.IsValid() || rv.Kind() != reflect.Interface {
+		return nil, newLookupError(ErrNotInterface, "non interface type: %s", reflect.TypeOf(v))
+	}
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	keys := d.implementedBy("", rv.Type())
+	list := make([]interface{}, len(keys))
+	for i, key := range keys {
+		list[i] = d.reg[key].Service
+	}
+	return list, nil
+}
+
+// FindNamed interface
+func (d *disco) FindNamed(name string) (interface{}, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for _, key := range d.sortedKeys() {
+		if reg := d.reg[key]; reg.Name == name && name != "" {
+			return reg.Service, nil
+		}
+	}
+	return nil, errors.Errorf("not registered: %s", name)
 }
 
 // ForEach interface
-func (d *disco) ForEach(v interface{}, f func(typ string) error) error {
+func (d *disco) ForEach(v interface{}, f func(key string, svc interface{}) error) error {
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
-		return errors.Errorf("a pointer to interface is required, invalid type: %v", rv)
+		return newLookupError(ErrNotPointer, "a pointer to interface is required, invalid type: %v", rv)
 	}
 
 	rv = rv.Elem()
 	if !rv.IsValid() || rv.Kind() != reflect.Interface {
-		return errors.Errorf("non interface type: %s", reflect.TypeOf(v))
+		return newLookupError(ErrNotInterface, "non interface type: %s", reflect.TypeOf(v))
 	}
 
+	// collect the matching services under the lock,
+	// the callback is invoked without holding it
+	d.lock.RLock()
+	keys := d.implementedBy("", rv.Type())
+	found := make([]ServiceInfo, len(keys))
+	for i, key := range keys {
+		found[i] = d.reg[key]
+	}
+	d.lock.RUnlock()
+
+	for i, reg := range found {
+		err := f(keys[i], reg.Service)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to execute callback for %s", reg.Type.String())
+		}
+	}
+	return nil
+}
+
+// Keys interface
+func (d *disco) Keys() []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.sortedKeys()
+}
+
+// Services interface
+func (d *disco) Services() []ServiceInfo {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	keys := d.sortedKeys()
+	list := make([]ServiceInfo, len(keys))
+	for i, key := range keys {
+		list[i] = d.reg[key]
+	}
+	return list
+}
+
+// sortedKeys returns sorted registration keys,
+// the caller must hold the lock
+func (d *disco) sortedKeys() []string {
+	keys := make([]string, 0, len(d.reg))
+	for key := range d.reg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// implementedBy returns sorted keys of the services
+// implementing the interface, the caller must hold the lock.
+// If server is not empty, only its services are considered
+func (d *disco) implementedBy(server string, typ reflect.Type) []string {
+	var keys []string
 	for key, reg := range d.reg {
-		if reg.Type.Implements(rv.Type()) {
-			rv.Set(reflect.ValueOf(reg.Service))
-			err := f(key)
-			if err != nil {
-				return errors.WithMessagef(err, "failed to execute callback for %s", reg.Type.String())
-			}
+		if server != "" && reg.ServerName != server {
+			continue
+		}
+		if reg.Type.Implements(typ) {
+			keys = append(keys, key)
 		}
 	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setService sets the service to the interface value,
+// instead of panic, an error is returned if the service is not assignable
+func setService(rv reflect.Value, service interface{}) error {
+	sv := reflect.ValueOf(service)
+	if !sv.IsValid() || !sv.Type().AssignableTo(rv.Type()) {
+		return errors.Errorf("not assignable: %T to %s", service, rv.Type())
+	}
+	rv.Set(sv)
 	return nil
 }
+
+func registryKey(server string, typ reflect.Type) string {
+	return fmt.Sprintf("%s/%s", server, typ.String())
+}
@@ -1,6 +1,8 @@
 package discovery_test
 
 import (
+	"fmt"
+	"sync"
 	"testing"
 
 	"github.com/effective-security/porto/pkg/discovery"
@@ -30,40 +32,285 @@ func TestDiscovery(t *testing.T) {
 	assert.Equal(t, f.GetName(), f2.GetName())
 
 	count := 0
-	err = d.ForEach(&f2, func(key string) error {
+	var f3 foo
+	err = d.ForEach(&f3, func(key string, svc interface{}) error {
 		count++
+		assert.Equal(t, "TestDiscovery/*discovery_test.fooImpl", key)
+		assert.Same(t, f, svc)
 		return nil
 	})
 	require.NoError(t, err)
-	require.NotNil(t, f2)
+	// the target is not modified
+	assert.Nil(t, f3)
 	assert.Equal(t, 1, count)
 
 	var nonPointer bar
 	err = d.Find(nonPointer)
 	require.EqualError(t, err, "a pointer to interface is required, invalid type: <invalid reflect.Value>")
+	assert.ErrorIs(t, err, discovery.ErrNotPointer)
 
-	err = d.Find(err)
+	err = d.Find(errors.New("concrete"))
 	require.EqualError(t, err, "non interface type: *errors.fundamental")
+	assert.ErrorIs(t, err, discovery.ErrNotInterface)
 
 	err = d.Find(&err)
 	require.EqualError(t, err, "not implemented: <error Value>")
+	assert.ErrorIs(t, err, discovery.ErrNotImplemented)
+	assert.NotErrorIs(t, err, discovery.ErrNotInterface)
 
-	err = d.ForEach(nonPointer, func(key string) error {
+	err = d.ForEach(nonPointer, func(key string, svc interface{}) error {
 		return nil
 	})
 	require.EqualError(t, err, "a pointer to interface is required, invalid type: <invalid reflect.Value>")
 
-	err = d.ForEach(err, func(key string) error {
+	err = d.ForEach(errors.New("concrete"), func(key string, svc interface{}) error {
 		return nil
 	})
 	require.EqualError(t, err, "non interface type: *errors.fundamental")
 
-	err = d.ForEach(&nonPointer, func(key string) error {
+	err = d.ForEach(&nonPointer, func(key string, svc interface{}) error {
 		return errors.Errorf("callback failed")
 	})
 	require.EqualError(t, err, "failed to execute callback for *discovery_test.barImpl: callback failed")
 }
 
+func TestUnregister(t *testing.T) {
+	srv := "TestUnregister"
+	d := discovery.New()
+
+	err := d.Unregister(srv, &fooImpl{})
+	require.EqualError(t, err, "not registered: TestUnregister/*discovery_test.fooImpl")
+
+	err = d.Register(srv, &fooImpl{})
+	require.NoError(t, err)
+	err = d.Unregister(srv, &fooImpl{})
+	require.NoError(t, err)
+
+	var f foo
+	err = d.Find(&f)
+	require.EqualError(t, err, "not implemented: <discovery_test.foo Value>")
+
+	// re-register after unregister
+	err = d.Register(srv, &fooImpl{})
+	require.NoError(t, err)
+	err = d.Find(&f)
+	require.NoError(t, err)
+	assert.NotNil(t, f)
+}
+
+func TestFindAll(t *testing.T) {
+	d := discovery.New()
+
+	f1 := &namedFoo{name: "f1"}
+	f2 := &namedFoo{name: "f2"}
+	require.NoError(t, d.Register("srv2", f2))
+	require.NoError(t, d.Register("srv1", f1))
+	require.NoError(t, d.Register("srv1", &barImpl{}))
+
+	var f foo
+	list, err := d.FindAll(&f)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Same(t, f1, list[0])
+	assert.Same(t, f2, list[1])
+
+	var e error
+	list, err = d.FindAll(&e)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+
+	_, err = d.FindAll(f)
+	require.EqualError(t, err, "a pointer to interface is required, invalid type: <invalid reflect.Value>")
+	_, err = d.FindAll(f1)
+	require.EqualError(t, err, "non interface type: *discovery_test.namedFoo")
+}
+
+func TestFindOrder(t *testing.T) {
+	d := discovery.New()
+
+	f1 := &namedFoo{name: "f1"}
+	f2 := &namedFoo{name: "f2"}
+	require.NoError(t, d.Register("b", f2))
+	require.NoError(t, d.Register("a", f1))
+	require.NoError(t, d.Register("c", &fooImpl{}))
+
+	for i := 0; i < 10; i++ {
+		var f foo
+		err := d.Find(&f)
+		require.NoError(t, err)
+		assert.Equal(t, "f1", f.GetName())
+	}
+}
+
+func TestFindForServer(t *testing.T) {
+	d := discovery.New()
+
+	require.NoError(t, d.Register("primary", &namedFoo{name: "primary"}))
+	require.NoError(t, d.Register("secondary", &namedFoo{name: "secondary"}))
+	require.NoError(t, d.Register("other", &barImpl{}))
+
+	var f foo
+	err := d.FindForServer("secondary", &f)
+	require.NoError(t, err)
+	assert.Equal(t, "secondary", f.GetName())
+
+	err = d.FindForServer("primary", &f)
+	require.NoError(t, err)
+	assert.Equal(t, "primary", f.GetName())
+
+	err = d.FindForServer("other", &f)
+	require.EqualError(t, err, "not implemented by other: <discovery_test.foo Value>")
+	assert.ErrorIs(t, err, discovery.ErrNotImplemented)
+	err = d.FindForServer("missing", &f)
+	require.EqualError(t, err, "not implemented by missing: <discovery_test.foo Value>")
+
+	err = d.FindForServer("primary", f)
+	require.EqualError(t, err, "non interface type: *discovery_test.namedFoo")
+}
+
+func TestKeysAndServices(t *testing.T) {
+	d := discovery.New()
+	assert.Empty(t, d.Keys())
+	assert.Empty(t, d.Services())
+
+	f := &fooImpl{}
+	b := &barImpl{}
+	require.NoError(t, d.Register("srv2", f))
+	require.NoError(t, d.Register("srv1", b))
+
+	keys := d.Keys()
+	assert.Equal(t, []string{"srv1/*discovery_test.barImpl", "srv2/*discovery_test.fooImpl"}, keys)
+
+	list := d.Services()
+	require.Len(t, list, 2)
+	assert.Equal(t, "srv1", list[0].ServerName)
+	assert.Equal(t, "*discovery_test.barImpl", list[0].Type.String())
+	assert.Same(t, b, list[0].Service)
+	assert.Equal(t, "srv2", list[1].ServerName)
+
+	// returned lists are copies
+	keys[0] = "modified"
+	list[0].ServerName = "modified"
+	assert.Equal(t, "srv1/*discovery_test.barImpl", d.Keys()[0])
+	assert.Equal(t, "srv1", d.Services()[0].ServerName)
+}
+
+func TestRegisterNamed(t *testing.T) {
+	d := discovery.New()
+	primary := &namedFoo{name: "primary"}
+	secondary := &namedFoo{name: "secondary"}
+
+	require.NoError(t, d.RegisterNamed("srv2", "db", secondary))
+	require.NoError(t, d.RegisterNamed("srv1", "db", primary))
+	require.NoError(t, d.RegisterNamed("srv1", "cache", secondary))
+	err := d.RegisterNamed("srv1", "db", secondary)
+	assert.EqualError(t, err, "already registered: srv1/db")
+	err = d.RegisterNamed("srv1", "", secondary)
+	assert.EqualError(t, err, "name is required")
+
+	assert.Equal(t, []string{"srv1/cache", "srv1/db", "srv2/db"}, d.Keys())
+	assert.Equal(t, "db", d.Services()[1].Name)
+
+	svc, err := d.FindNamed("db")
+	require.NoError(t, err)
+	assert.Same(t, primary, svc)
+
+	svc, err = d.FindNamed("cache")
+	require.NoError(t, err)
+	assert.Same(t, secondary, svc)
+
+	_, err = d.FindNamed("queue")
+	assert.EqualError(t, err, "not registered: queue")
+
+	// the named services are found by interface
+	var f foo
+	list, err := d.FindAll(&f)
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+
+	require.NoError(t, d.UnregisterNamed("srv1", "db"))
+	err = d.UnregisterNamed("srv1", "db")
+	assert.EqualError(t, err, "not registered: srv1/db")
+
+	svc, err = d.FindNamed("db")
+	require.NoError(t, err)
+	assert.Same(t, secondary, svc)
+}
+
+func TestFindPointerReceiver(t *testing.T) {
+	d := discovery.New()
+	// fooImpl implements foo only via pointer receiver
+	require.NoError(t, d.Register("srv", fooImpl{}))
+
+	var f foo
+	assert.NotPanics(t, func() {
+		err := d.Find(&f)
+		assert.EqualError(t, err, "not implemented: <discovery_test.foo Value>")
+		err = d.FindForServer("srv", &f)
+		assert.EqualError(t, err, "not implemented by srv: <discovery_test.foo Value>")
+	})
+	assert.Nil(t, f)
+
+	require.NoError(t, d.Register("srv", &fooImpl{}))
+	require.NoError(t, d.Find(&f))
+	assert.Equal(t, "foo", f.GetName())
+}
+
+func TestDiscoveryConcurrent(t *testing.T) {
+	d := discovery.New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			err := d.Register(fmt.Sprintf("srv%d", i), &fooImpl{})
+			assert.NoError(t, err)
+		}(i)
+		go func() {
+			defer wg.Done()
+			var f foo
+			_ = d.Find(&f)
+			_ = d.ForEach(&f, func(key string, svc interface{}) error {
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	count := 0
+	var f foo
+	err := d.ForEach(&f, func(key string, svc interface{}) error {
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 10, count)
+}
+
+func TestForEachReentrant(t *testing.T) {
+	d := discovery.New()
+	require.NoError(t, d.Register("srv1", &fooImpl{}))
+	require.NoError(t, d.Register("srv2", &fooImpl{}))
+
+	var keys []string
+	var f foo
+	err := d.ForEach(&f, func(key string, svc interface{}) error {
+		keys = append(keys, key)
+		if len(keys) > 1 {
+			return nil
+		}
+		// the registry can be modified by the callback,
+		// the changes are not visible in the current iteration
+		require.NoError(t, d.Register("srv3", &fooImpl{}))
+		assert.Len(t, d.Keys(), 3)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.Len(t, d.Keys(), 3)
+}
+
 type foo interface {
 	GetName() string
 }
@@ -72,6 +319,12 @@ type fooImpl struct{}
 
 func (f *fooImpl) GetName() string { return "foo" }
 
+type namedFoo struct {
+	name string
+}
+
+func (f *namedFoo) GetName() string { return f.name }
+
 type bar interface {
 	IsSupported() bool
 }
@@ -0,0 +1,45 @@
+package discovery
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotPointer is returned when the provided value is not a non-nil pointer.
+	// Use errors.Is to check for it.
+	ErrNotPointer = errors.New("a pointer to interface is required")
+	// ErrNotInterface is returned when the provided pointer does not point to
+	// an interface, for example a pointer to a concrete type.
+	// Use errors.Is to check for it.
+	ErrNotInterface = errors.New("non interface type")
+	// ErrNotImplemented is returned when none of the registered services
+	// implements the interface. Use errors.Is to check for it.
+	ErrNotImplemented = errors.New("not implemented")
+)
+
+// lookupError describes the failed lookup,
+// and matches the sentinel error with errors.Is
+type lookupError struct {
+	err error
+	msg string
+}
+
+func (e *lookupError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the sentinel error
+func (e *lookupError) Unwrap() error {
+	return e.err
+}
+
+// newLookupError returns the error with the formatted message,
+// that wraps the sentinel error
+func newLookupError(err error, format string, args ...interface{}) error {
+	return errors.WithStack(&lookupError{
+		err: err,
+		msg: fmt.Sprintf(format, args...),
+	})
+}
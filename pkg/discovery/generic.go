@@ -0,0 +1,19 @@
+package discovery
+
+import (
+	"reflect"
+)
+
+// Get returns the service assignable to T.
+// If multiple services match, the one with the lowest registration key
+// is returned, same as in Find
+func Get[T any](d Discovery) (T, error) {
+	for _, info := range d.Services() {
+		if s, ok := info.Service.(T); ok {
+			return s, nil
+		}
+	}
+
+	var zero T
+	return zero, newLookupError(ErrNotImplemented, "not implemented: %s", reflect.TypeOf((*T)(nil)).Elem())
+}
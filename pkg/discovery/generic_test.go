@@ -0,0 +1,37 @@
+package discovery_test
+
+import (
+	"testing"
+
+	"github.com/effective-security/porto/pkg/discovery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGet(t *testing.T) {
+	d := discovery.New()
+
+	_, err := discovery.Get[foo](d)
+	require.EqualError(t, err, "not implemented: discovery_test.foo")
+	assert.ErrorIs(t, err, discovery.ErrNotImplemented)
+
+	require.NoError(t, d.Register("b", &namedFoo{name: "f2"}))
+	require.NoError(t, d.Register("a", &namedFoo{name: "f1"}))
+	require.NoError(t, d.Register("a", &barImpl{}))
+
+	f, err := discovery.Get[foo](d)
+	require.NoError(t, err)
+	assert.Equal(t, "f1", f.GetName())
+
+	b, err := discovery.Get[bar](d)
+	require.NoError(t, err)
+	assert.True(t, b.IsSupported())
+
+	// concrete type
+	nf, err := discovery.Get[*namedFoo](d)
+	require.NoError(t, err)
+	assert.Equal(t, "f1", nf.GetName())
+
+	_, err = discovery.Get[*fooImpl](d)
+	require.EqualError(t, err, "not implemented: *discovery_test.fooImpl")
+}
@@ -0,0 +1,26 @@
+package discovery
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_setService(t *testing.T) {
+	var r io.Reader
+	rv := reflect.ValueOf(&r).Elem()
+
+	err := setService(rv, struct{}{})
+	assert.EqualError(t, err, "not assignable: struct {} to io.Reader")
+	assert.Nil(t, r)
+
+	err = setService(rv, nil)
+	assert.EqualError(t, err, "not assignable: <nil> to io.Reader")
+
+	err = setService(rv, io.MultiReader())
+	require.NoError(t, err)
+	assert.NotNil(t, r)
+}
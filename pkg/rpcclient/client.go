@@ -3,19 +3,33 @@ package rpcclient
 import (
 	"context"
 	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
 	"math"
+	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	tcredentials "github.com/effective-security/porto/gserver/credentials"
+	"github.com/effective-security/porto/pkg/tlsconfig"
 	"github.com/effective-security/porto/x/slices"
+	"github.com/effective-security/porto/xhttp/correlation"
 	"github.com/effective-security/porto/xhttp/pberror"
 	"github.com/effective-security/xlog"
 	"github.com/effective-security/xpki/jwt/dpop"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	// register gzip compressor
+	_ "google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/keepalive"
 )
 
@@ -38,51 +52,277 @@ var (
 	defaultMaxCallRecvMsgSize = grpc.MaxCallRecvMsgSize(math.MaxInt32)
 )
 
-// defaultCallOpts defines a list of default "gRPC.CallOption".
-// Some options are exposed to "client.Config".
-// Defaults will be overridden by the settings in "client.Config".
-var defaultCallOpts = []grpc.CallOption{
-	defaultWaitForReady,
-	defaultMaxCallSendMsgSize,
-	defaultMaxCallRecvMsgSize,
-}
-
 // Client provides and manages v1 client session.
 type Client struct {
+	*session
+
+	// scopeCtx is the context of the client returned by WithContext,
+	// it's nil for the client returned by New
+	scopeCtx context.Context
+}
+
+// session is the state shared by the client and its scoped clients
+type session struct {
 	cfg      Config
-	conn     *grpc.ClientConn
+	opts     options
+	target   string
+	conns    []*grpc.ClientConn
+	next     uint32
 	callOpts []grpc.CallOption
 
+	// inflight is the number of outstanding calls,
+	// closing is set by CloseGracefully to reject new calls
+	inflight int64
+	closing  uint32
+
+	// healthStatus is the last status reported by the gRPC Health service
+	healthStatus int32
+
+	// creds and dopts are preserved to re-dial on Reconnect
+	creds credentials.TransportCredentials
+	dopts []grpc.DialOption
+
+	tlsReloader *tlsconfig.KeypairReloader
+	// peerCerts is the server certificate chain of the last TLS handshake
+	peerCerts []*x509.Certificate
+	// stats is set if Config.EnableStats is specified
+	stats *statsHandler
+
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	//lock sync.RWMutex
+	lock sync.RWMutex
 }
 
 // NewFromURL creates a new client from a URL.
-func NewFromURL(url string) (*Client, error) {
+func NewFromURL(url string, ops ...Option) (*Client, error) {
 	return New(&Config{
 		Endpoints: []string{url},
-	})
+	}, ops...)
 }
 
 // New creates a new client from a given configuration.
-func New(cfg *Config) (*Client, error) {
-	return newClient(cfg)
+func New(cfg *Config, ops ...Option) (*Client, error) {
+	return newClient(cfg, ops...)
+}
+
+// WithContext returns a scoped client, that uses ctx as the base context
+// for the subsequent operations, such as Reconnect, instead of Config.Context.
+// The scoped client shares the underlying grpc.ClientConn, the configuration
+// and the statistics with the client, no new connection is created.
+// Close and CloseGracefully of the scoped client do not close
+// the shared connections, the client returned by New owns them.
+// If ctx is nil, the client is returned unchanged
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		return c
+	}
+	return &Client{
+		session:  c.session,
+		scopeCtx: ctx,
+	}
+}
+
+// Context returns the base context of the client,
+// that is the context provided to WithContext for the scoped client,
+// or the client context that is canceled on Close
+func (c *Client) Context() context.Context {
+	if c.scopeCtx != nil {
+		return c.scopeCtx
+	}
+	return c.ctx
 }
 
 // Close shuts down the client's connections.
+// Close of the scoped client returned by WithContext is no-op
 func (c *Client) Close() error {
+	if c.scopeCtx != nil {
+		return nil
+	}
 	c.cancel()
-	if c.conn != nil {
-		return toErr(c.ctx, c.conn.Close())
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tlsReloader != nil {
+		_ = c.tlsReloader.Close()
+		c.tlsReloader = nil
+	}
+	if len(c.conns) > 0 {
+		var err error
+		for _, conn := range c.conns {
+			if cerr := conn.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		return toErr(c.ctx, err)
 	}
 	return c.ctx.Err()
 }
 
-// Conn returns the current in-use connection
+// CloseGracefully rejects new calls, waits for the outstanding calls
+// to finish or the context to be done, and then closes the connections.
+// If the context is done before the calls finished, the context error is returned
+func (c *Client) CloseGracefully(ctx context.Context) error {
+	if c.scopeCtx != nil {
+		return nil
+	}
+	atomic.StoreUint32(&c.closing, 1)
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&c.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			logger.KV(xlog.WARNING,
+				"reason", "close_timeout",
+				"inflight", atomic.LoadInt64(&c.inflight))
+			_ = c.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return c.Close()
+}
+
+// Conn returns the current in-use connection,
+// if the pool is configured, the connections are returned round-robin
 func (c *Client) Conn() *grpc.ClientConn {
-	return c.conn
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	switch len(c.conns) {
+	case 0:
+		return nil
+	case 1:
+		return c.conns[0]
+	}
+	n := atomic.AddUint32(&c.next, 1) - 1
+	return c.conns[n%uint32(len(c.conns))]
+}
+
+// connections returns a copy of the connections pool
+func (c *Client) connections() []*grpc.ClientConn {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]*grpc.ClientConn{}, c.conns...)
+}
+
+// WaitForConnected blocks until all connections are READY,
+// or the context is done.
+// If HealthCheckService is configured, it also waits
+// until the gRPC Health service reports SERVING
+func (c *Client) WaitForConnected(ctx context.Context) error {
+	for _, conn := range c.connections() {
+		if err := waitForConnected(ctx, conn); err != nil {
+			return err
+		}
+	}
+	if c.cfg.HealthCheckService != "" {
+		return c.waitForServing(ctx)
+	}
+	return nil
+}
+
+func waitForConnected(ctx context.Context, conn *grpc.ClientConn) error {
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.Shutdown:
+			return errors.Errorf("connection is closed")
+		case connectivity.Idle:
+			conn.Connect()
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return toErr(ctx, ctx.Err())
+		}
+	}
+}
+
+// Reconnect closes the current connections and dials the endpoint again
+func (c *Client) Reconnect() error {
+	for _, conn := range c.connections() {
+		if err := c.reconnect(conn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reconnect replaces the connection in the pool with a new one
+func (c *Client) reconnect(old *grpc.ClientConn) error {
+	dialEndpoint := c.cfg.Endpoints[0]
+	logger.KV(xlog.INFO, "reconnect", dialEndpoint)
+
+	conn, err := c.dial(dialEndpoint, c.creds, c.dopts...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.lock.Lock()
+	if c.ctx.Err() != nil {
+		// the client is closed
+		c.lock.Unlock()
+		_ = conn.Close()
+		return toErr(c.ctx, c.ctx.Err())
+	}
+	replaced := false
+	for i, cc := range c.conns {
+		if cc == old {
+			c.conns[i] = conn
+			replaced = true
+			break
+		}
+	}
+	c.lock.Unlock()
+
+	if !replaced {
+		// the connection was already replaced
+		_ = conn.Close()
+		return nil
+	}
+
+	go c.monitorConnState(conn)
+
+	_ = old.Close()
+	return nil
+}
+
+// monitorConnState logs the connection state transitions,
+// reports them to OnConnStateChange, and re-dials on TransientFailure if AutoReconnect is enabled
+func (c *Client) monitorConnState(conn *grpc.ClientConn) {
+	state := conn.GetState()
+	for conn.WaitForStateChange(c.ctx, state) {
+		newState := conn.GetState()
+		logger.KV(xlog.DEBUG,
+			"target", conn.Target(),
+			"state", newState.String(),
+			"previous", state.String())
+
+		state = newState
+		if c.cfg.OnConnStateChange != nil {
+			c.cfg.OnConnStateChange(state)
+		}
+		switch state {
+		case connectivity.Shutdown:
+			return
+		case connectivity.TransientFailure:
+			if c.cfg.AutoReconnect {
+				if err := c.reconnect(conn); err != nil {
+					logger.KV(xlog.ERROR, "target", conn.Target(), "err", err.Error())
+					continue
+				}
+				return
+			}
+		}
+	}
+}
+
+// Target returns the resolved target that the client dialed
+func (c *Client) Target() string {
+	return c.target
 }
 
 // Opts returns the current Call options
@@ -90,7 +330,7 @@ func (c *Client) Opts() []grpc.CallOption {
 	return c.callOpts
 }
 
-func newClient(cfg *Config) (*Client, error) {
+func newClient(cfg *Config, ops ...Option) (*Client, error) {
 
 	if cfg == nil || len(cfg.Endpoints) < 1 {
 		return nil, errors.Errorf("at least one Endpoint must is required in client config")
@@ -104,26 +344,71 @@ func newClient(cfg *Config) (*Client, error) {
 
 	ctx, cancel := context.WithCancel(baseCtx)
 	client := &Client{
-		conn:     nil,
-		cfg:      *cfg,
-		ctx:      ctx,
-		cancel:   cancel,
-		callOpts: defaultCallOpts,
+		session: &session{
+			cfg:      *cfg,
+			ctx:      ctx,
+			cancel:   cancel,
+			callOpts: callOpts(cfg),
+		},
+	}
+	if cfg.EnableStats {
+		client.stats = &statsHandler{}
+	}
+
+	for _, op := range ops {
+		op.apply(&client.opts)
+	}
+
+	if cfg.Compression != "" {
+		if encoding.GetCompressor(cfg.Compression) == nil {
+			cancel()
+			return nil, errors.Errorf("compressor is not registered: %s", cfg.Compression)
+		}
+		client.callOpts = append(client.callOpts, grpc.UseCompressor(cfg.Compression))
 	}
 
 	dialEndpoint := cfg.Endpoints[0]
+	client.target = client.dialTarget(dialEndpoint)
+
+	tlsCfg := cfg.TLS
+	if tlsCfg == nil && cfg.InsecureSkipVerify {
+		tlsCfg = &tls.Config{
+			InsecureSkipVerify: true,
+		}
+	}
 
 	var dopts []grpc.DialOption
 	var creds credentials.TransportCredentials
-	if cfg.TLS != nil &&
-		(strings.HasPrefix(dialEndpoint, "https://") || strings.HasPrefix(dialEndpoint, "unixs://")) {
+	if tlsCfg != nil && isSecure(dialEndpoint) {
+		if cfg.TLS == nil {
+			logger.KV(xlog.WARNING,
+				"reason", "insecure_skip_verify",
+				"endpoint", dialEndpoint,
+				"warning", "the server certificate is not verified, do not use in production")
+		}
+		if tlsCfg.ServerName == "" && !strings.HasPrefix(dialEndpoint, "unixs://") {
+			tlsCfg = tlsCfg.Clone()
+			tlsCfg.ServerName = hostName(dialEndpoint)
+		}
 
-		bundle := tcredentials.NewBundle(tcredentials.Config{TLSConfig: cfg.TLS})
-		creds = bundle.TransportCredentials()
+		bcfg := tcredentials.Config{TLSConfig: tlsCfg}
+		if cfg.TLSReloadInterval > 0 && cfg.TLSCertFile != "" {
+			tlsReloader, err := tlsconfig.NewKeypairReloader("", cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSReloadInterval)
+			if err != nil {
+				cancel()
+				return nil, errors.WithMessage(err, "unable to load TLS key pair")
+			}
+			client.tlsReloader = tlsReloader
+			bcfg.GetClientCertificate = tlsReloader.GetClientCertificateFunc()
+		}
+
+		bundle := tcredentials.NewBundle(bcfg)
+		creds = newPeerCredentials(bundle.TransportCredentials(), client.setPeerCertificates)
 
 		at, err := cfg.LoadAuthToken()
 		if err == nil {
 			if at.Expired() {
+				_ = client.Close()
 				return nil, errors.Errorf("authorization: token expired")
 			}
 			// grpc: the credentials require transport level security
@@ -132,11 +417,13 @@ func newClient(cfg *Config) (*Client, error) {
 			if at.DpopJkt != "" {
 				k, _, err := cfg.Storage().LoadKey(at.DpopJkt)
 				if err != nil {
+					_ = client.Close()
 					return nil, errors.WithMessage(err, "unable to load key for DPoP")
 				}
 				typ = "DPoP"
 				signer, err := dpop.NewSigner(k.Key.(crypto.Signer))
 				if err != nil {
+					_ = client.Close()
 					return nil, errors.WithMessage(err, "unable to create DPoP signer")
 				}
 				bundle.WithDPoP(signer)
@@ -147,19 +434,153 @@ func newClient(cfg *Config) (*Client, error) {
 		dopts = append(dopts, grpc.WithPerRPCCredentials(bundle.PerRPCCredentials()))
 	}
 
-	logger.KV(xlog.TRACE, "dial", dialEndpoint)
-	conn, err := client.dial(dialEndpoint, creds, dopts...)
-	if err != nil {
-		client.cancel()
-		return nil, errors.WithStack(err)
+	client.creds = creds
+	client.dopts = dopts
+
+	poolSize := cfg.ConnPoolSize
+	if poolSize < 1 {
+		poolSize = 1
+	}
+
+	logger.KV(xlog.TRACE, "dial", dialEndpoint, "pool_size", poolSize)
+	for i := 0; i < poolSize; i++ {
+		conn, err := client.dial(dialEndpoint, creds, dopts...)
+		if err != nil {
+			_ = client.Close()
+			return nil, errors.WithStack(err)
+		}
+		client.conns = append(client.conns, conn)
+	}
+
+	for _, conn := range client.conns {
+		go client.monitorConnState(conn)
+	}
+	if cfg.HealthCheckService != "" {
+		go client.monitorHealth()
 	}
 
-	client.conn = conn
 	return client, nil
 }
 
+// callOpts returns a list of "gRPC.CallOption".
+// Some options are exposed to "client.Config".
+// Defaults will be overridden by the settings in "client.Config".
+func callOpts(cfg *Config) []grpc.CallOption {
+	waitForReady := defaultWaitForReady
+	if cfg.WaitForReady != nil {
+		waitForReady = grpc.WaitForReady(*cfg.WaitForReady)
+	}
+	sendMsgSize := defaultMaxCallSendMsgSize
+	if cfg.MaxCallSendMsgSize > 0 {
+		sendMsgSize = grpc.MaxCallSendMsgSize(cfg.MaxCallSendMsgSize)
+	}
+	recvMsgSize := defaultMaxCallRecvMsgSize
+	if cfg.MaxCallRecvMsgSize > 0 {
+		recvMsgSize = grpc.MaxCallRecvMsgSize(cfg.MaxCallRecvMsgSize)
+	}
+	return []grpc.CallOption{
+		waitForReady,
+		sendMsgSize,
+		recvMsgSize,
+	}
+}
+
 var removePrefix = strings.NewReplacer("https://", "", "http://", "", "unixs://", "", "unix://", "")
 
+// dnsScheme is the gRPC DNS resolver scheme
+const dnsScheme = "dns:///"
+
+// isSecure returns true if the endpoint is dialed with TLS,
+// when the TLS config is provided
+func isSecure(endpoint string) bool {
+	return strings.HasPrefix(endpoint, "https://") ||
+		strings.HasPrefix(endpoint, "unixs://") ||
+		strings.HasPrefix(endpoint, dnsScheme)
+}
+
+// dialTarget returns gRPC target for the endpoint.
+// The unix sockets are dialed with gRPC "unix:" scheme,
+// the "dns:///" scheme is preserved for gRPC DNS resolver,
+// otherwise the default port is appended if not specified:
+// 80 for "http://" cleartext endpoints, and 443 for others.
+func dialTarget(endpoint string) string {
+	if strings.HasPrefix(endpoint, "unix://") || strings.HasPrefix(endpoint, "unixs://") {
+		return "unix:" + removePrefix.Replace(endpoint)
+	}
+	if strings.HasPrefix(endpoint, dnsScheme) {
+		target := strings.TrimPrefix(endpoint, dnsScheme)
+		if !strings.Contains(target, ":") {
+			target += ":443"
+		}
+		return dnsScheme + target
+	}
+
+	target := removePrefix.Replace(endpoint)
+	if !strings.Contains(target, ":") {
+		if strings.HasPrefix(endpoint, "http://") {
+			target += ":80"
+		} else {
+			target += ":443"
+		}
+	}
+	return target
+}
+
+// passthroughScheme is the gRPC passthrough resolver scheme
+const passthroughScheme = "passthrough:///"
+
+// dialTarget returns gRPC target for the endpoint,
+// the endpoints are not resolved with the custom Dialer
+func (c *Client) dialTarget(endpoint string) string {
+	if c.cfg.Dialer == nil {
+		return dialTarget(endpoint)
+	}
+	if strings.HasPrefix(endpoint, passthroughScheme) {
+		return endpoint
+	}
+	return passthroughScheme + removePrefix.Replace(endpoint)
+}
+
+// serviceConfig returns the default gRPC service config JSON,
+// or empty string if not configured.
+// The LoadBalancingPolicy is added to ServiceConfigJSON,
+// if it does not specify the load balancing config
+func serviceConfig(cfg *Config) (string, error) {
+	if cfg.ServiceConfigJSON == "" {
+		if cfg.LoadBalancingPolicy == "" {
+			return "", nil
+		}
+		return fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, cfg.LoadBalancingPolicy), nil
+	}
+
+	var sc map[string]interface{}
+	if err := json.Unmarshal([]byte(cfg.ServiceConfigJSON), &sc); err != nil {
+		return "", errors.WithMessage(err, "invalid service config")
+	}
+	_, hasLB := sc["loadBalancingConfig"]
+	_, hasPolicy := sc["loadBalancingPolicy"]
+	if cfg.LoadBalancingPolicy == "" || hasLB || hasPolicy {
+		return cfg.ServiceConfigJSON, nil
+	}
+	sc["loadBalancingConfig"] = []interface{}{
+		map[string]interface{}{cfg.LoadBalancingPolicy: map[string]interface{}{}},
+	}
+	js, err := json.Marshal(sc)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return string(js), nil
+}
+
+// hostName returns the host name of the endpoint without port
+func hostName(endpoint string) string {
+	host := removePrefix.Replace(strings.TrimPrefix(endpoint, dnsScheme))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
 // dial configures and dials any grpc balancer target.
 func (c *Client) dial(target string, creds credentials.TransportCredentials, dopts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	opts, err := c.dialSetupOpts(creds, dopts...)
@@ -168,26 +589,24 @@ func (c *Client) dial(target string, creds credentials.TransportCredentials, dop
 	}
 
 	opts = append(opts, c.cfg.DialOptions...)
-	dctx := c.ctx
+	dctx := c.Context()
 
 	if c.cfg.DialTimeout > 0 {
 		opts = append(opts, grpc.WithBlock())
 
 		var cancel context.CancelFunc
-		dctx, cancel = context.WithTimeout(c.ctx, c.cfg.DialTimeout)
+		dctx, cancel = context.WithTimeout(dctx, c.cfg.DialTimeout)
 		defer cancel()
 	}
 
-	target = removePrefix.Replace(target)
-	if !strings.Contains(target, ":") {
-		target += ":443"
-	}
+	target = c.dialTarget(target)
 
 	logger.KV(xlog.DEBUG, "target", target, "timeout", c.cfg.DialTimeout)
 
 	conn, err := grpc.DialContext(dctx, target, opts...)
 	if err != nil {
-		return nil, err
+		return nil, errors.WithMessagef(err, "unable to dial: target=%s, tls=%t, timeout=%s",
+			target, creds != nil, c.cfg.DialTimeout)
 	}
 
 	logger.KV(xlog.DEBUG, "target", target, "status", "connecton_created")
@@ -204,8 +623,52 @@ func (c *Client) dialSetupOpts(creds credentials.TransportCredentials, dopts ...
 		}
 		opts = append(opts, grpc.WithKeepaliveParams(params))
 	}
+	if c.cfg.Dialer != nil {
+		opts = append(opts, grpc.WithContextDialer(c.cfg.Dialer))
+	}
+	if c.stats != nil {
+		opts = append(opts, grpc.WithStatsHandler(c.stats))
+	}
 	opts = append(opts, dopts...)
 
+	// the built-in interceptors are invoked first,
+	// followed by the interceptors from the config
+	unary := []grpc.UnaryClientInterceptor{c.inflightUnaryInterceptor()}
+	stream := []grpc.StreamClientInterceptor{c.inflightStreamInterceptor()}
+	if c.cfg.PropagateCorrelation {
+		unary = append(unary, correlation.NewUnaryClientInterceptor())
+		stream = append(stream, correlation.NewStreamClientInterceptor())
+	}
+	if c.opts.tracing {
+		unary = append(unary, newTracingUnaryInterceptor())
+		stream = append(stream, newTracingStreamInterceptor())
+	}
+	if c.cfg.MetadataFromContext != nil {
+		unary = append(unary, newMetadataUnaryInterceptor(c.cfg.MetadataFromContext))
+		stream = append(stream, newMetadataStreamInterceptor(c.cfg.MetadataFromContext))
+	}
+	if c.cfg.CallTimeout > 0 {
+		unary = append(unary, newTimeoutUnaryInterceptor(c.cfg.CallTimeout))
+	}
+	if isIdempotent := idempotentPredicate(&c.cfg); c.cfg.MaxRetries > 0 && isIdempotent != nil {
+		unary = append(unary, newRetryUnaryInterceptor(c.cfg.MaxRetries, newRetryBudget(c.cfg.RetryBudgetRatio), isIdempotent))
+	}
+	unary = append(unary, c.cfg.UnaryInterceptors...)
+	stream = append(stream, c.cfg.StreamInterceptors...)
+
+	sc, err := serviceConfig(&c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if sc != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(sc))
+	}
+
+	opts = append(opts,
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+	)
+
 	if creds == nil {
 		creds = insecure.NewCredentials()
 	}
@@ -1,12 +1,28 @@
 package rpcclient_test
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/effective-security/porto/pkg/rpcclient"
+	"github.com/effective-security/porto/xhttp/correlation"
+	"github.com/effective-security/porto/xhttp/header"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 func TestNew(t *testing.T) {
@@ -22,8 +38,735 @@ func TestNew(t *testing.T) {
 	client, err := rpcclient.NewFromURL(lis.Addr().String())
 	require.NoError(t, err)
 
+	assert.Equal(t, lis.Addr().String(), client.Target())
 	assert.NotEmpty(t, client.Opts())
 	assert.NotNil(t, client.Conn())
 
 	defer client.Close()
 }
+
+func TestNewWithTracing(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	client, err := rpcclient.NewFromURL(lis.Addr().String(), rpcclient.WithTracing())
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.NotNil(t, client.Conn())
+}
+
+func TestWaitForConnected(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer()
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:     []string{lis.Addr().String()},
+		AutoReconnect: true,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.WaitForConnected(ctx))
+
+	conn := client.Conn()
+	require.NoError(t, client.Reconnect())
+	assert.True(t, conn != client.Conn())
+	require.NoError(t, client.WaitForConnected(ctx))
+
+	client.Close()
+	assert.Error(t, client.Reconnect())
+}
+
+func TestOnConnStateChange(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer()
+	go serv.Serve(lis)
+
+	states := make(chan connectivity.State, 100)
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints: []string{lis.Addr().String()},
+		OnConnStateChange: func(state connectivity.State) {
+			states <- state
+		},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.WaitForConnected(ctx))
+
+	waitFor := func(expected connectivity.State) {
+		for {
+			select {
+			case state := <-states:
+				if state == expected {
+					return
+				}
+			case <-ctx.Done():
+				t.Fatalf("state not reported: %s", expected)
+			}
+		}
+	}
+	waitFor(connectivity.Ready)
+
+	// flap
+	serv.Stop()
+	waitFor(connectivity.Idle)
+}
+
+func TestConnPool(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer()
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:    []string{lis.Addr().String()},
+		ConnPoolSize: 3,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	c1 := client.Conn()
+	c2 := client.Conn()
+	c3 := client.Conn()
+	assert.True(t, c1 != c2)
+	assert.True(t, c2 != c3)
+	assert.True(t, c1 != c3)
+	// round-robin
+	assert.True(t, c1 == client.Conn())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.WaitForConnected(ctx))
+
+	require.NoError(t, client.Reconnect())
+	for i := 0; i < 3; i++ {
+		conn := client.Conn()
+		assert.True(t, conn != c1 && conn != c2 && conn != c3)
+	}
+	require.NoError(t, client.WaitForConnected(ctx))
+
+	require.NoError(t, client.Close())
+	assert.Equal(t, connectivity.Shutdown, client.Conn().GetState())
+	assert.Equal(t, connectivity.Shutdown, client.Conn().GetState())
+	assert.Equal(t, connectivity.Shutdown, client.Conn().GetState())
+}
+
+func TestCloseGracefully(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	serv := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		started <- struct{}{}
+		<-release
+		return stream.SendMsg(&emptypb.Empty{})
+	}))
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	newClient := func() *rpcclient.Client {
+		client, err := rpcclient.NewFromURL(lis.Addr().String())
+		require.NoError(t, err)
+		return client
+	}
+
+	t.Run("drained", func(t *testing.T) {
+		client := newClient()
+
+		callErr := make(chan error, 1)
+		go func() {
+			callErr <- client.Conn().Invoke(context.Background(), "/test.Service/Method", &emptypb.Empty{}, &emptypb.Empty{}, client.Opts()...)
+		}()
+		<-started
+
+		closed := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			closed <- client.CloseGracefully(ctx)
+		}()
+
+		// new calls are rejected
+		assert.Eventually(t, func() bool {
+			err := client.Conn().Invoke(context.Background(), "/test.Service/Method", &emptypb.Empty{}, &emptypb.Empty{}, client.Opts()...)
+			return status.Code(err) == codes.Unavailable
+		}, time.Second, 10*time.Millisecond)
+
+		select {
+		case <-closed:
+			t.Fatal("closed before the call finished")
+		default:
+		}
+
+		release <- struct{}{}
+		require.NoError(t, <-callErr)
+		require.NoError(t, <-closed)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		client := newClient()
+
+		go func() {
+			_ = client.Conn().Invoke(context.Background(), "/test.Service/Method", &emptypb.Empty{}, &emptypb.Empty{}, client.Opts()...)
+		}()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, client.CloseGracefully(ctx))
+		close(release)
+	})
+}
+
+func TestWaitForConnectedTimeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	client, err := rpcclient.NewFromURL(addr)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, client.WaitForConnected(ctx))
+}
+
+func TestDialError(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	lis.Close()
+
+	_, err = rpcclient.New(&rpcclient.Config{
+		Endpoints:   []string{addr},
+		DialTimeout: 100 * time.Millisecond,
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to dial: target="+addr+", tls=false, timeout=100ms")
+}
+
+func TestNewWithCompression(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer lis.Close()
+
+	_, err = rpcclient.New(&rpcclient.Config{
+		Endpoints:   []string{lis.Addr().String()},
+		Compression: "unknown",
+	})
+	require.EqualError(t, err, "compressor is not registered: unknown")
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:   []string{lis.Addr().String()},
+		Compression: "gzip",
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	assert.Len(t, client.Opts(), 4)
+}
+
+func TestDialCleartext(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		return stream.SendMsg(&emptypb.Empty{})
+	}))
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints: []string{"http://" + lis.Addr().String()},
+		// TLS is not used for cleartext endpoints
+		TLS: &tls.Config{},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, lis.Addr().String(), client.Target())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Conn().Invoke(ctx, "/test.Service/Method", &emptypb.Empty{}, &emptypb.Empty{}, client.Opts()...)
+	require.NoError(t, err)
+}
+
+func TestDialDNS(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		return stream.SendMsg(&emptypb.Empty{})
+	}))
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	endpoint := "dns:///" + lis.Addr().String()
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:           []string{endpoint},
+		LoadBalancingPolicy: "round_robin",
+	})
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, endpoint, client.Target())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Conn().Invoke(ctx, "/test.Service/Method", &emptypb.Empty{}, &emptypb.Empty{}, client.Opts()...)
+	require.NoError(t, err)
+
+	// invalid policy fails the dial
+	_, err = rpcclient.New(&rpcclient.Config{
+		Endpoints:           []string{endpoint},
+		LoadBalancingPolicy: "unknown",
+	})
+	assert.Error(t, err)
+}
+
+func TestDialDNSWithTLS(t *testing.T) {
+	pair, err := tls.LoadX509KeyPair("../../gserver/testdata/test-server.pem", "../../gserver/testdata/test-server-key.pem")
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(lis.Addr().String())
+	require.NoError(t, err)
+
+	serverNames := make(chan string, 10)
+	serv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			serverNames <- hello.ServerName
+			return &pair, nil
+		},
+	})))
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:           []string{"dns:///localhost:" + port},
+		LoadBalancingPolicy: "round_robin",
+		TLS:                 &tls.Config{InsecureSkipVerify: true},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.WaitForConnected(ctx))
+	assert.NotEmpty(t, client.PeerCertificates())
+	assert.Equal(t, "localhost", <-serverNames)
+}
+
+func TestDialUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	lis, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+
+	serv := grpc.NewServer()
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.NewFromURL("unix://" + sock)
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.WaitForConnected(ctx))
+}
+
+func TestInterceptorsOrder(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer()
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	var calls []string
+	unary := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			calls = append(calls, name)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+	stream := func(name string) grpc.StreamClientInterceptor {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			calls = append(calls, name)
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+	}
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:          []string{lis.Addr().String()},
+		UnaryInterceptors:  []grpc.UnaryClientInterceptor{unary("u1"), unary("u2")},
+		StreamInterceptors: []grpc.StreamClientInterceptor{stream("s1"), stream("s2")},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = client.Conn().Invoke(ctx, "/test.Service/Method", &emptypb.Empty{}, &emptypb.Empty{}, client.Opts()...)
+	require.Error(t, err)
+	assert.Equal(t, []string{"u1", "u2"}, calls)
+
+	calls = nil
+	_, _ = client.Conn().NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/test.Service/Stream", client.Opts()...)
+	assert.Equal(t, []string{"s1", "s2"}, calls)
+}
+
+func TestTLSReload(t *testing.T) {
+	certFile := "../../gserver/testdata/test-server.pem"
+	keyFile := "../../gserver/testdata/test-server-key.pem"
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAnyClientCert,
+	})))
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	_, err = rpcclient.New(&rpcclient.Config{
+		Endpoints:         []string{"https://" + lis.Addr().String()},
+		TLS:               &tls.Config{InsecureSkipVerify: true},
+		TLSCertFile:       "notfound.pem",
+		TLSKeyFile:        keyFile,
+		TLSReloadInterval: time.Minute,
+	})
+	require.Error(t, err)
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:         []string{"https://" + lis.Addr().String()},
+		TLS:               &tls.Config{InsecureSkipVerify: true},
+		TLSCertFile:       certFile,
+		TLSKeyFile:        keyFile,
+		TLSReloadInterval: time.Minute,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.WaitForConnected(ctx))
+}
+
+func TestPeerCertificates(t *testing.T) {
+	certFile := "../../gserver/testdata/test-server.pem"
+	keyFile := "../../gserver/testdata/test-server-key.pem"
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{pair},
+	})))
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints: []string{"https://" + lis.Addr().String()},
+		TLS:       &tls.Config{InsecureSkipVerify: true},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.WaitForConnected(ctx))
+
+	certs := client.PeerCertificates()
+	require.NotEmpty(t, certs)
+	assert.Equal(t, pair.Certificate[0], certs[0].Raw)
+
+	// cleartext connection has no certificates
+	client2, err := rpcclient.New(&rpcclient.Config{
+		Endpoints: []string{"http://" + lis.Addr().String()},
+	})
+	require.NoError(t, err)
+	defer client2.Close()
+	assert.Empty(t, client2.PeerCertificates())
+}
+
+func TestInsecureSkipVerify(t *testing.T) {
+	certFile := "../../gserver/testdata/test-server.pem"
+	keyFile := "../../gserver/testdata/test-server-key.pem"
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{pair},
+	})))
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:          []string{"https://" + lis.Addr().String()},
+		InsecureSkipVerify: true,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.WaitForConnected(ctx))
+	assert.NotEmpty(t, client.PeerCertificates())
+
+	// the explicit TLS config takes precedence
+	client2, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:          []string{"https://" + lis.Addr().String()},
+		TLS:                &tls.Config{},
+		InsecureSkipVerify: true,
+	})
+	require.NoError(t, err)
+	defer client2.Close()
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel2()
+	assert.Error(t, client2.WaitForConnected(ctx2))
+}
+
+func TestHealthCheck(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	hs := health.NewServer()
+	hs.SetServingStatus("test", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	serv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(serv, hs)
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:           []string{"http://" + lis.Addr().String()},
+		HealthCheckService:  "test",
+		HealthCheckInterval: 20 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = client.WaitForConnected(ctx)
+	require.Error(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, client.HealthStatus())
+
+	hs.SetServingStatus("test", grpc_health_v1.HealthCheckResponse_SERVING)
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel2()
+	require.NoError(t, client.WaitForConnected(ctx2))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, client.HealthStatus())
+
+	// the status is polled in background
+	hs.SetServingStatus("test", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	assert.Eventually(t, func() bool {
+		return client.HealthStatus() == grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+
+	// not configured
+	client2, err := rpcclient.New(&rpcclient.Config{
+		Endpoints: []string{"http://" + lis.Addr().String()},
+	})
+	require.NoError(t, err)
+	defer client2.Close()
+	require.NoError(t, client2.WaitForConnected(ctx2))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_UNKNOWN, client2.HealthStatus())
+}
+
+func TestDialer(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+
+	hs := health.NewServer()
+	serv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(serv, hs)
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	var addrs []string
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		addrs = append(addrs, addr)
+		return lis.DialContext(ctx)
+	}
+
+	for _, ep := range []string{"bufnet", "http://bufnet", "passthrough:///bufnet"} {
+		addrs = nil
+		client, err := rpcclient.New(&rpcclient.Config{
+			Endpoints:   []string{ep},
+			Dialer:      dialer,
+			DialTimeout: 5 * time.Second,
+		})
+		require.NoError(t, err, ep)
+		assert.Equal(t, "passthrough:///bufnet", client.Target())
+
+		res, err := grpc_health_v1.NewHealthClient(client.Conn()).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		require.NoError(t, err, ep)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, res.Status)
+		assert.Equal(t, []string{"bufnet"}, addrs)
+		client.Close()
+	}
+}
+
+func TestServiceConfig(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(serv, health.NewServer())
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:         []string{"http://" + lis.Addr().String()},
+		ServiceConfigJSON: `{"methodConfig":[{"name":[{"service":"grpc.health.v1.Health"}],"timeout":"5s"}]}`,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(client.Conn()).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	_, err = rpcclient.New(&rpcclient.Config{
+		Endpoints:         []string{"http://" + lis.Addr().String()},
+		ServiceConfigJSON: `{invalid`,
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid service config")
+
+	// the valid JSON is validated by gRPC
+	_, err = rpcclient.New(&rpcclient.Config{
+		Endpoints:         []string{"http://" + lis.Addr().String()},
+		ServiceConfigJSON: `{"loadBalancingConfig":[{"unknown_policy":{}}]}`,
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service config is invalid")
+}
+
+func TestPropagateCorrelation(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	var received []string
+	serv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		received = md.Get(header.XCorrelationID)
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(serv, health.NewServer())
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	check := func(ctx context.Context, cfg *rpcclient.Config) {
+		client, err := rpcclient.New(cfg)
+		require.NoError(t, err)
+		defer client.Close()
+
+		received = nil
+		_, err = grpc_health_v1.NewHealthClient(client.Conn()).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+		require.NoError(t, err)
+	}
+
+	cfg := &rpcclient.Config{
+		Endpoints:            []string{"http://" + lis.Addr().String()},
+		PropagateCorrelation: true,
+	}
+
+	ctx := correlation.WithID(context.Background())
+	check(ctx, cfg)
+	assert.Equal(t, []string{correlation.ID(ctx)}, received)
+
+	// generated if absent
+	check(context.Background(), cfg)
+	require.Len(t, received, 1)
+	assert.Len(t, received[0], correlation.IDSize)
+
+	// disabled by default
+	cfg.PropagateCorrelation = false
+	check(ctx, cfg)
+	assert.Empty(t, received)
+}
+
+func TestWithContext(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	serv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(serv, health.NewServer())
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints: []string{"http://" + lis.Addr().String()},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scoped := client.WithContext(ctx)
+	assert.Equal(t, ctx, scoped.Context())
+	assert.NotEqual(t, ctx, client.Context())
+	// the connection is shared
+	assert.Same(t, client.Conn(), scoped.Conn())
+	assert.Equal(t, client.Target(), scoped.Target())
+
+	var nilCtx context.Context
+	assert.Same(t, client, client.WithContext(nilCtx))
+	assert.Same(t, scoped, scoped.WithContext(nilCtx))
+
+	hc := grpc_health_v1.NewHealthClient(scoped.Conn())
+	_, err = hc.Check(scoped.Context(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+
+	cancel()
+	_, err = hc.Check(scoped.Context(), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	// the scoped client does not close the shared connection
+	require.NoError(t, scoped.Close())
+	require.NoError(t, client.Context().Err())
+	_, err = hc.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+}
+
+func TestTarget(t *testing.T) {
+	client, err := rpcclient.NewFromURL("https://localhost:8443")
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, "localhost:8443", client.Target())
+
+	client2, err := rpcclient.NewFromURL("localhost")
+	require.NoError(t, err)
+	defer client2.Close()
+	assert.Equal(t, "localhost:443", client2.Target())
+}
@@ -3,20 +3,51 @@ package rpcclient
 import (
 	"context"
 	"crypto/tls"
+	"net"
 	"time"
 
 	"github.com/effective-security/porto/pkg/retriable"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
 )
 
 // Config for the client
 type Config struct {
 	// Endpoints is a list of URLs.
+	// The "https://" and "unixs://" endpoints are dialed with TLS,
+	// any other endpoints, such as "http://" or "unix://",
+	// are dialed over cleartext HTTP/2 (h2c), regardless of the TLS config.
+	// The cleartext must be used only on trusted networks.
+	// The "dns:///" endpoints are resolved by gRPC DNS resolver to all addresses,
+	// for example behind Kubernetes headless services,
+	// see LoadBalancingPolicy to balance the calls across them.
+	// They are dialed with TLS, if TLS or InsecureSkipVerify is specified,
+	// and the server name is the host of the DNS target.
 	Endpoints []string
 
+	// LoadBalancingPolicy specifies the gRPC load balancing policy,
+	// for example "round_robin". If not specified, gRPC uses "pick_first".
+	LoadBalancingPolicy string
+
+	// Dialer specifies the custom dialer to create the connections,
+	// for example for in-memory bufconn listener or a proxy.
+	// If specified, the endpoints are dialed with gRPC "passthrough" resolver,
+	// and the address is passed to the Dialer without the default port.
+	Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+	// ServiceConfigJSON specifies the default gRPC service config,
+	// for example to configure the timeouts and retry policies per method,
+	// see https://github.com/grpc/grpc/blob/master/doc/service_config.md
+	ServiceConfigJSON string
+
 	// DialTimeout is the timeout for failing to establish a connection.
 	DialTimeout time.Duration
 
+	// CallTimeout is the default timeout for the unary calls,
+	// it is applied only if the call context does not have a deadline.
+	CallTimeout time.Duration
+
 	// DialKeepAliveTime is the time after which client pings the server to see if
 	// transport is alive.
 	DialKeepAliveTime time.Duration
@@ -25,14 +56,118 @@ type Config struct {
 	// keep-alive probe. If the response is not received in this time, the connection is closed.
 	DialKeepAliveTimeout time.Duration
 
+	// ConnPoolSize specifies the number of connections to the endpoint,
+	// the connections are used round-robin by Conn().
+	// If not specified, a single connection is used.
+	ConnPoolSize int
+
+	// HealthCheckService specifies the service name to check
+	// with the gRPC Health service, the endpoint is considered ready
+	// by WaitForConnected only when the service is SERVING.
+	// If not specified, the health is not checked.
+	HealthCheckService string
+
+	// HealthCheckInterval specifies the interval to poll the health status.
+	// If 0, it defaults to 1 second.
+	HealthCheckInterval time.Duration
+
+	// AutoReconnect specifies to re-dial the endpoint,
+	// when the connection enters TransientFailure state.
+	AutoReconnect bool
+
+	// OnConnStateChange is called when a connection of the client
+	// changes the state, for example to report the connection flaps.
+	// It's called from the connection monitor goroutine,
+	// that exits when the client is closed, and must not block.
+	OnConnStateChange func(state connectivity.State)
+
 	// TLS holds the client secure credentials, if any.
 	TLS *tls.Config
 
+	// InsecureSkipVerify specifies to skip the verification of the server certificate,
+	// when TLS is not specified. It must be used only for the local development
+	// with self-signed certificates, and never in production.
+	InsecureSkipVerify bool
+
+	// TLSCertFile and TLSKeyFile specify the client certificate and key files,
+	// that are reloaded from disk if TLSReloadInterval is specified.
+	// Only new connections use the reloaded certificate.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSReloadInterval specifies the interval to check for the client certificate updates.
+	TLSReloadInterval time.Duration
+
 	// DialOptions is a list of dial options for the grpc client (e.g., for interceptors).
 	// For example, pass "grpc.WithBlock()" to block until the underlying connection is up.
 	// Without this, Dial returns immediately and connecting the server happens in background.
 	DialOptions []grpc.DialOption
 
+	// UnaryInterceptors is a list of interceptors for the unary calls.
+	// The interceptors are invoked in the specified order,
+	// after the built-in interceptors, such as tracing.
+	UnaryInterceptors []grpc.UnaryClientInterceptor
+
+	// StreamInterceptors is a list of interceptors for the stream calls.
+	// The interceptors are invoked in the specified order,
+	// after the built-in interceptors, such as tracing.
+	StreamInterceptors []grpc.StreamClientInterceptor
+
+	// MetadataFromContext is invoked for each outgoing call,
+	// the returned metadata is merged in the outgoing metadata.
+	// The keys already present in the outgoing metadata are not overridden.
+	MetadataFromContext func(ctx context.Context) metadata.MD
+
+	// MaxRetries specifies the number of retries for the unary calls
+	// of the idempotent methods, that failed with Unavailable status.
+	// If 0, the calls are not retried.
+	// The methods are not retried, unless IsIdempotent or IdempotentMethods
+	// is specified, to prevent the duplicate side effects.
+	MaxRetries int
+
+	// IsIdempotent returns true if the full method name, for example
+	// `/grpc.health.v1.Health/Check`, is safe to retry.
+	IsIdempotent func(method string) bool
+
+	// IdempotentMethods specifies the list of full method names,
+	// that are safe to retry, for example `/grpc.health.v1.Health/Check`.
+	// The name ending with `/*` matches all methods of the service,
+	// for example `/grpc.health.v1.Health/*`.
+	// It's used if IsIdempotent is not specified.
+	IdempotentMethods []string
+
+	// RetryBudgetRatio specifies the ratio of a token added to the retry budget
+	// on each successful call, each failed call consumes a token.
+	// The retries are allowed only while the budget is more than half full,
+	// which prevents the retry storms when the server is unavailable.
+	// If 0, it defaults to 0.1, that is the retries are throttled
+	// when more than 1 of 10 calls fails.
+	RetryBudgetRatio float64
+
+	// MaxCallSendMsgSize is the client-side request send limit in bytes.
+	// If 0, it defaults to 2MB.
+	MaxCallSendMsgSize int
+
+	// MaxCallRecvMsgSize is the client-side response receive limit in bytes.
+	// If 0, it defaults to math.MaxInt32.
+	MaxCallRecvMsgSize int
+
+	// WaitForReady specifies to block the calls until the connection is ready,
+	// instead of failing fast. If not specified, it defaults to true.
+	WaitForReady *bool
+
+	// Compression specifies the name of the compressor for the outgoing calls,
+	// for example "gzip". The compressor must be registered with gRPC encoding.
+	Compression string
+
+	// EnableStats specifies to collect the statistics of the calls,
+	// such as the number of calls and bytes, see Client.Stats
+	EnableStats bool
+
+	// PropagateCorrelation specifies to set the Correlation ID from the context
+	// in the outgoing metadata of the calls, a new ID is generated if
+	// the context does not have it
+	PropagateCorrelation bool
+
 	// Context is the default client context; it can be used to cancel grpc dial out and
 	// other operations that do not have an explicit context.
 	Context context.Context
@@ -0,0 +1,85 @@
+package rpcclient
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/effective-security/xlog"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// DefaultHealthCheckInterval specifies the default interval to poll the health status
+const DefaultHealthCheckInterval = time.Second
+
+// HealthStatus returns the last serving status reported by the gRPC Health service,
+// or UNKNOWN if HealthCheckService is not configured or not checked yet
+func (c *Client) HealthStatus() grpc_health_v1.HealthCheckResponse_ServingStatus {
+	return grpc_health_v1.HealthCheckResponse_ServingStatus(atomic.LoadInt32(&c.healthStatus))
+}
+
+func (c *Client) healthCheckInterval() time.Duration {
+	if c.cfg.HealthCheckInterval > 0 {
+		return c.cfg.HealthCheckInterval
+	}
+	return DefaultHealthCheckInterval
+}
+
+// checkHealth calls the gRPC Health service, and stores the serving status
+func (c *Client) checkHealth(ctx context.Context) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	cctx, cancel := context.WithTimeout(ctx, c.healthCheckInterval())
+	defer cancel()
+
+	status := grpc_health_v1.HealthCheckResponse_UNKNOWN
+	res, err := grpc_health_v1.NewHealthClient(c.Conn()).Check(cctx,
+		&grpc_health_v1.HealthCheckRequest{Service: c.cfg.HealthCheckService})
+	if err != nil {
+		if ctx.Err() != nil {
+			// the check is canceled by the caller, keep the last status
+			return status
+		}
+		logger.KV(xlog.DEBUG, "service", c.cfg.HealthCheckService, "err", err.Error())
+	} else {
+		status = res.Status
+	}
+
+	prev := atomic.SwapInt32(&c.healthStatus, int32(status))
+	if prev != int32(status) {
+		logger.KV(xlog.INFO,
+			"service", c.cfg.HealthCheckService,
+			"status", status.String(),
+			"previous", grpc_health_v1.HealthCheckResponse_ServingStatus(prev).String())
+	}
+	return status
+}
+
+// monitorHealth polls the gRPC Health service until the client is closed
+func (c *Client) monitorHealth() {
+	ticker := time.NewTicker(c.healthCheckInterval())
+	defer ticker.Stop()
+	for {
+		c.checkHealth(c.ctx)
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForServing blocks until the gRPC Health service reports SERVING,
+// or the context is done
+func (c *Client) waitForServing(ctx context.Context) error {
+	ticker := time.NewTicker(c.healthCheckInterval())
+	defer ticker.Stop()
+	for {
+		if c.checkHealth(ctx) == grpc_health_v1.HealthCheckResponse_SERVING {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return toErr(ctx, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package rpcclient
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// errClosing is returned for the calls started after CloseGracefully
+var errClosing = status.Error(codes.Unavailable, "client is closing")
+
+// startCall tracks the outstanding call,
+// the returned function must be called when the call is finished
+func (c *Client) startCall() (func(), error) {
+	// increment first, so CloseGracefully does not miss the call
+	atomic.AddInt64(&c.inflight, 1)
+	if atomic.LoadUint32(&c.closing) == 1 {
+		atomic.AddInt64(&c.inflight, -1)
+		return nil, errClosing
+	}
+	return func() {
+		atomic.AddInt64(&c.inflight, -1)
+	}, nil
+}
+
+// inflightUnaryInterceptor returns grpc.UnaryClientInterceptor that
+// tracks the outstanding calls
+func (c *Client) inflightUnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		done, err := c.startCall()
+		if err != nil {
+			return err
+		}
+		defer done()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// inflightStreamInterceptor returns grpc.StreamClientInterceptor that
+// tracks the outstanding streams, until the stream context is done
+func (c *Client) inflightStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		done, err := c.startCall()
+		if err != nil {
+			return nil, err
+		}
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			done()
+			return nil, err
+		}
+		go func() {
+			// the stream context is canceled when the stream is finished
+			<-cs.Context().Done()
+			done()
+		}()
+		return cs, nil
+	}
+}
+
+// newTimeoutUnaryInterceptor returns grpc.UnaryClientInterceptor that
+// applies the default timeout, if the context does not have a deadline
+func newTimeoutUnaryInterceptor(timeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := ctx.Deadline(); !ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// withMetadata returns context with the metadata merged in the outgoing metadata,
+// the keys already present in the outgoing metadata are not overridden
+func withMetadata(ctx context.Context, fn func(ctx context.Context) metadata.MD) context.Context {
+	md := fn(ctx)
+	if len(md) == 0 {
+		return ctx
+	}
+
+	out, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		out = out.Copy()
+	} else {
+		out = metadata.MD{}
+	}
+	for k, v := range md {
+		if len(out.Get(k)) == 0 {
+			out.Append(k, v...)
+		}
+	}
+	return metadata.NewOutgoingContext(ctx, out)
+}
+
+// newMetadataUnaryInterceptor returns grpc.UnaryClientInterceptor that
+// merges the metadata derived from the context in the outgoing metadata
+func newMetadataUnaryInterceptor(fn func(ctx context.Context) metadata.MD) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(withMetadata(ctx, fn), method, req, reply, cc, opts...)
+	}
+}
+
+// newMetadataStreamInterceptor returns grpc.StreamClientInterceptor that
+// merges the metadata derived from the context in the outgoing metadata
+func newMetadataStreamInterceptor(fn func(ctx context.Context) metadata.MD) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withMetadata(ctx, fn), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,82 @@
+package rpcclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func Test_TimeoutInterceptor(t *testing.T) {
+	unary := newTimeoutUnaryInterceptor(time.Minute)
+
+	var deadline time.Time
+	var hasDeadline bool
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		deadline, hasDeadline = ctx.Deadline()
+		return nil
+	}
+
+	err := unary(context.Background(), "/test", nil, nil, nil, invoker)
+	require.NoError(t, err)
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	// caller-supplied deadline must not be overridden
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	expected, _ := ctx.Deadline()
+
+	err = unary(ctx, "/test", nil, nil, nil, invoker)
+	require.NoError(t, err)
+	assert.True(t, hasDeadline)
+	assert.Equal(t, expected, deadline)
+}
+
+type tenantKey struct{}
+
+func Test_MetadataInterceptor(t *testing.T) {
+	fn := func(ctx context.Context) metadata.MD {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		if tenant == "" {
+			return nil
+		}
+		return metadata.Pairs("x-tenant", tenant, "x-source", "client")
+	}
+
+	var md metadata.MD
+	unary := newMetadataUnaryInterceptor(fn)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+
+	err := unary(context.Background(), "/test", nil, nil, nil, invoker)
+	require.NoError(t, err)
+	assert.Empty(t, md)
+
+	ctx := context.WithValue(context.Background(), tenantKey{}, "t1")
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-source", "call", "k1", "v1")
+
+	err = unary(ctx, "/test", nil, nil, nil, invoker)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"t1"}, md.Get("x-tenant"))
+	assert.Equal(t, []string{"v1"}, md.Get("k1"))
+	// call-site values are not overridden
+	assert.Equal(t, []string{"call"}, md.Get("x-source"))
+
+	stream := newMetadataStreamInterceptor(fn)
+	md = nil
+	_, err = stream(ctx, nil, nil, "/test",
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil, nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"t1"}, md.Get("x-tenant"))
+	assert.Equal(t, []string{"call"}, md.Get("x-source"))
+}
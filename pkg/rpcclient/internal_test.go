@@ -0,0 +1,120 @@
+package rpcclient
+
+import (
+	"crypto/tls"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func Test_dialTarget(t *testing.T) {
+	tcases := []struct {
+		endpoint string
+		exp      string
+	}{
+		{"localhost", "localhost:443"},
+		{"localhost:8080", "localhost:8080"},
+		{"https://localhost", "localhost:443"},
+		{"https://localhost:8443", "localhost:8443"},
+		{"http://localhost:8080", "localhost:8080"},
+		{"http://localhost", "localhost:80"},
+		{"unix://localhost:8080", "unix:localhost:8080"},
+		{"unixs://localhost", "unix:localhost"},
+		{"unix:///tmp/test.sock", "unix:/tmp/test.sock"},
+		{"dns:///service.ns.svc", "dns:///service.ns.svc:443"},
+		{"dns:///service.ns.svc:8080", "dns:///service.ns.svc:8080"},
+	}
+
+	for _, tc := range tcases {
+		assert.Equal(t, tc.exp, dialTarget(tc.endpoint), "failed for: %s", tc.endpoint)
+	}
+}
+
+func Test_serviceConfig(t *testing.T) {
+	sc, err := serviceConfig(&Config{})
+	require.NoError(t, err)
+	assert.Empty(t, sc)
+
+	sc, err = serviceConfig(&Config{LoadBalancingPolicy: "round_robin"})
+	require.NoError(t, err)
+	assert.Equal(t, `{"loadBalancingConfig":[{"round_robin":{}}]}`, sc)
+
+	methods := `{"methodConfig":[{"name":[{"service":"test"}],"timeout":"1s"}]}`
+	sc, err = serviceConfig(&Config{ServiceConfigJSON: methods})
+	require.NoError(t, err)
+	assert.Equal(t, methods, sc)
+
+	sc, err = serviceConfig(&Config{ServiceConfigJSON: methods, LoadBalancingPolicy: "round_robin"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"loadBalancingConfig":[{"round_robin":{}}],"methodConfig":[{"name":[{"service":"test"}],"timeout":"1s"}]}`, sc)
+
+	// the service config takes precedence
+	lb := `{"loadBalancingConfig":[{"pick_first":{}}]}`
+	sc, err = serviceConfig(&Config{ServiceConfigJSON: lb, LoadBalancingPolicy: "round_robin"})
+	require.NoError(t, err)
+	assert.Equal(t, lb, sc)
+
+	_, err = serviceConfig(&Config{ServiceConfigJSON: "{invalid"})
+	assert.EqualError(t, err, "invalid service config: invalid character 'i' looking for beginning of object key string")
+}
+
+func Test_hostName(t *testing.T) {
+	tcases := []struct {
+		endpoint string
+		exp      string
+	}{
+		{"localhost", "localhost"},
+		{"localhost:8080", "localhost"},
+		{"https://localhost", "localhost"},
+		{"https://10.0.0.1:8443", "10.0.0.1"},
+		{"https://[::1]:8443", "::1"},
+	}
+
+	for _, tc := range tcases {
+		assert.Equal(t, tc.exp, hostName(tc.endpoint), "failed for: %s", tc.endpoint)
+	}
+}
+
+func Test_ServerName(t *testing.T) {
+	tlsCfg := &tls.Config{}
+	c, err := New(&Config{
+		Endpoints: []string{"https://10.0.0.1:8443"},
+		TLS:       tlsCfg,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	assert.Equal(t, "10.0.0.1:8443", c.Target())
+	assert.Empty(t, tlsCfg.ServerName)
+	assert.Equal(t, "10.0.0.1", c.creds.Info().ServerName)
+
+	c2, err := New(&Config{
+		Endpoints: []string{"https://10.0.0.1:8443"},
+		TLS:       &tls.Config{ServerName: "custom"},
+	})
+	require.NoError(t, err)
+	defer c2.Close()
+	assert.Equal(t, "custom", c2.creds.Info().ServerName)
+}
+
+func Test_callOpts(t *testing.T) {
+	opts := callOpts(&Config{})
+	require.Len(t, opts, 3)
+	assert.Equal(t, grpc.FailFastCallOption{FailFast: false}, opts[0])
+	assert.Equal(t, grpc.MaxSendMsgSizeCallOption{MaxSendMsgSize: 2 * 1024 * 1024}, opts[1])
+	assert.Equal(t, grpc.MaxRecvMsgSizeCallOption{MaxRecvMsgSize: math.MaxInt32}, opts[2])
+
+	waitForReady := false
+	opts = callOpts(&Config{
+		MaxCallSendMsgSize: 10 * 1024 * 1024,
+		MaxCallRecvMsgSize: 1024,
+		WaitForReady:       &waitForReady,
+	})
+	require.Len(t, opts, 3)
+	assert.Equal(t, grpc.FailFastCallOption{FailFast: true}, opts[0])
+	assert.Equal(t, grpc.MaxSendMsgSizeCallOption{MaxSendMsgSize: 10 * 1024 * 1024}, opts[1])
+	assert.Equal(t, grpc.MaxRecvMsgSizeCallOption{MaxRecvMsgSize: 1024}, opts[2])
+}
@@ -0,0 +1,35 @@
+package rpcclient
+
+// Option is an option that can be passed to New().
+// Option configures how we set up the client
+type Option interface {
+	apply(*options)
+}
+
+// WithTracing option to propagate the trace context
+// and Correlation ID in the outgoing gRPC metadata.
+// The trace context is injected with the global OpenTelemetry propagator,
+// see otel.SetTextMapPropagator
+func WithTracing() Option {
+	return newFuncOption(func(o *options) {
+		o.tracing = true
+	})
+}
+
+type options struct {
+	tracing bool
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (fo *funcOption) apply(o *options) {
+	fo.f(o)
+}
+
+func newFuncOption(f func(*options)) *funcOption {
+	return &funcOption{
+		f: f,
+	}
+}
@@ -0,0 +1,74 @@
+package rpcclient
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+)
+
+// peerCredentials wraps credentials.TransportCredentials
+// to capture the server certificates from the TLS handshake
+type peerCredentials struct {
+	credentials.TransportCredentials
+	onHandshake func(certs []*x509.Certificate)
+}
+
+func newPeerCredentials(creds credentials.TransportCredentials, onHandshake func(certs []*x509.Certificate)) credentials.TransportCredentials {
+	return &peerCredentials{
+		TransportCredentials: creds,
+		onHandshake:          onHandshake,
+	}
+}
+
+// ClientHandshake does the authentication handshake with the server
+func (c *peerCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, ai, err := c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+	if err == nil {
+		if info, ok := ai.(credentials.TLSInfo); ok {
+			certs := info.State.PeerCertificates
+			// prefer the verified chain, if available
+			if len(info.State.VerifiedChains) > 0 {
+				certs = info.State.VerifiedChains[0]
+			}
+			c.onHandshake(certs)
+		}
+	}
+	return conn, ai, err
+}
+
+// Clone makes a copy of the credentials,
+// the copy reports the handshakes to the same callback
+func (c *peerCredentials) Clone() credentials.TransportCredentials {
+	return newPeerCredentials(c.TransportCredentials.Clone(), c.onHandshake)
+}
+
+// setPeerCertificates stores the server certificates of the last handshake
+func (c *Client) setPeerCertificates(certs []*x509.Certificate) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.peerCerts = certs
+}
+
+// PeerCertificates returns the server certificate chain,
+// negotiated by the last TLS handshake.
+// The verified chain is returned, if the server certificate was verified.
+// It returns nil, if the client is not connected or TLS is not used.
+func (c *Client) PeerCertificates() []*x509.Certificate {
+	ready := false
+	for _, conn := range c.connections() {
+		if conn.GetState() == connectivity.Ready {
+			ready = true
+			break
+		}
+	}
+	if !ready {
+		return nil
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]*x509.Certificate(nil), c.peerCerts...)
+}
@@ -0,0 +1,142 @@
+package rpcclient
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/effective-security/porto/metricskey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultRetryBudgetRatio specifies the default number of tokens
+	// added to the retry budget on each successful call
+	DefaultRetryBudgetRatio = 0.1
+
+	// retryBudgetMaxTokens specifies the capacity of the retry budget,
+	// as in gRFC A6 retry throttling
+	retryBudgetMaxTokens = 10
+
+	// retryBackoff specifies the initial delay between retries
+	retryBackoff = 50 * time.Millisecond
+)
+
+// retryBudget implements token bucket of gRFC A6 retry throttling:
+// each failed call consumes a token, and each successful call adds ratio of a token.
+// The retries are allowed only while the bucket is more than half full
+type retryBudget struct {
+	lock      sync.Mutex
+	maxTokens float64
+	ratio     float64
+	tokens    float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	if ratio <= 0 {
+		ratio = DefaultRetryBudgetRatio
+	}
+	return &retryBudget{
+		maxTokens: retryBudgetMaxTokens,
+		ratio:     ratio,
+		tokens:    retryBudgetMaxTokens,
+	}
+}
+
+// onSuccess adds ratio of a token to the budget
+func (b *retryBudget) onSuccess() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
+
+// onFailure consumes a token, and returns true if the retry is allowed
+func (b *retryBudget) onFailure() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+	return b.tokens > b.maxTokens/2
+}
+
+// isRetriable returns true if the call failed before reaching the server
+func isRetriable(err error) bool {
+	return status.Code(err) == codes.Unavailable
+}
+
+// idempotentPredicate returns the predicate for the methods,
+// that are safe to retry, or nil if none is configured
+func idempotentPredicate(cfg *Config) func(method string) bool {
+	if cfg.IsIdempotent != nil {
+		return cfg.IsIdempotent
+	}
+	if len(cfg.IdempotentMethods) == 0 {
+		return nil
+	}
+	methods := make(map[string]bool, len(cfg.IdempotentMethods))
+	var services []string
+	for _, m := range cfg.IdempotentMethods {
+		if strings.HasSuffix(m, "/*") {
+			services = append(services, strings.TrimSuffix(m, "*"))
+		} else {
+			methods[m] = true
+		}
+	}
+	return func(method string) bool {
+		if methods[method] {
+			return true
+		}
+		for _, svc := range services {
+			if strings.HasPrefix(method, svc) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// newRetryUnaryInterceptor returns grpc.UnaryClientInterceptor that
+// retries the Unavailable calls of the idempotent methods up to maxRetries times,
+// while the retry budget allows.
+// The calls of other methods are not retried
+func newRetryUnaryInterceptor(maxRetries int, budget *retryBudget, isIdempotent func(method string) bool) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if !isIdempotent(method) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		backoff := retryBackoff
+		for attempt := 0; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil {
+				budget.onSuccess()
+				return nil
+			}
+			if !isRetriable(err) {
+				return err
+			}
+			if !budget.onFailure() {
+				metricskey.GRPCClientRetriesThrottled.IncrCounter(1, method)
+				return err
+			}
+			if attempt >= maxRetries {
+				return err
+			}
+
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+}
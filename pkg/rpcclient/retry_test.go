@@ -0,0 +1,121 @@
+package rpcclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_RetryBudget(t *testing.T) {
+	b := newRetryBudget(0)
+	assert.Equal(t, DefaultRetryBudgetRatio, b.ratio)
+
+	// 10 tokens, the retries are allowed while more than 5 left
+	for i := 0; i < 4; i++ {
+		assert.True(t, b.onFailure(), "failure %d", i)
+	}
+	assert.False(t, b.onFailure())
+	assert.False(t, b.onFailure())
+
+	// 4 tokens left, more than 20 successful calls are needed to allow the retry
+	for i := 0; i < 25; i++ {
+		b.onSuccess()
+	}
+	assert.True(t, b.onFailure())
+
+	for i := 0; i < 1000; i++ {
+		b.onSuccess()
+	}
+	assert.Equal(t, b.maxTokens, b.tokens)
+}
+
+func Test_RetryInterceptor(t *testing.T) {
+	var calls int
+	var failures int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		if calls <= failures {
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		return nil
+	}
+
+	all := func(string) bool { return true }
+
+	t.Run("retried", func(t *testing.T) {
+		calls, failures = 0, 2
+		unary := newRetryUnaryInterceptor(3, newRetryBudget(0), all)
+		err := unary(context.Background(), "/test", nil, nil, nil, invoker)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("max retries", func(t *testing.T) {
+		calls, failures = 0, 10
+		unary := newRetryUnaryInterceptor(1, newRetryBudget(0), all)
+		err := unary(context.Background(), "/test", nil, nil, nil, invoker)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("not retriable", func(t *testing.T) {
+		calls = 0
+		unary := newRetryUnaryInterceptor(3, newRetryBudget(0), all)
+		err := unary(context.Background(), "/test", nil, nil, nil,
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				calls++
+				return status.Error(codes.InvalidArgument, "invalid")
+			})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("not idempotent", func(t *testing.T) {
+		calls, failures = 0, 2
+		unary := newRetryUnaryInterceptor(3, newRetryBudget(0), func(method string) bool {
+			return method == "/test.Service/Get"
+		})
+		err := unary(context.Background(), "/test.Service/Charge", nil, nil, nil, invoker)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Equal(t, 1, calls)
+
+		calls = 0
+		err = unary(context.Background(), "/test.Service/Get", nil, nil, nil, invoker)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		calls, failures = 0, 100
+		unary := newRetryUnaryInterceptor(100, newRetryBudget(0), all)
+		err := unary(context.Background(), "/test", nil, nil, nil, invoker)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+		// the budget allows 4 retries
+		assert.Equal(t, 5, calls)
+	})
+}
+
+func Test_idempotentPredicate(t *testing.T) {
+	assert.Nil(t, idempotentPredicate(&Config{}))
+
+	isIdempotent := idempotentPredicate(&Config{
+		IdempotentMethods: []string{"/test.Service/Get", "/grpc.health.v1.Health/*"},
+	})
+	require.NotNil(t, isIdempotent)
+	assert.True(t, isIdempotent("/test.Service/Get"))
+	assert.True(t, isIdempotent("/grpc.health.v1.Health/Check"))
+	assert.False(t, isIdempotent("/test.Service/Charge"))
+	assert.False(t, isIdempotent("/grpc.health.v1.HealthX/Check"))
+
+	// the predicate takes precedence
+	isIdempotent = idempotentPredicate(&Config{
+		IsIdempotent:      func(string) bool { return false },
+		IdempotentMethods: []string{"/test.Service/Get"},
+	})
+	assert.False(t, isIdempotent("/test.Service/Get"))
+}
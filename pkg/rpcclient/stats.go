@@ -0,0 +1,85 @@
+package rpcclient
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc/stats"
+)
+
+// Stats provides the accumulated statistics of the client calls
+type Stats struct {
+	// Calls is the number of started calls
+	Calls uint64
+	// Failures is the number of calls that ended with an error
+	Failures uint64
+	// BytesSent is the number of bytes sent on the wire,
+	// including the framing and compression
+	BytesSent uint64
+	// BytesReceived is the number of bytes received on the wire,
+	// including the framing and compression
+	BytesReceived uint64
+	// Connections is the number of established connections
+	Connections uint64
+}
+
+// statsHandler implements stats.Handler to accumulate the calls statistics
+type statsHandler struct {
+	calls         uint64
+	failures      uint64
+	bytesSent     uint64
+	bytesReceived uint64
+	connections   uint64
+}
+
+// TagRPC returns the context unchanged
+func (h *statsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+// HandleRPC processes the RPC stats
+func (h *statsHandler) HandleRPC(_ context.Context, s stats.RPCStats) {
+	switch st := s.(type) {
+	case *stats.Begin:
+		atomic.AddUint64(&h.calls, 1)
+	case *stats.OutPayload:
+		atomic.AddUint64(&h.bytesSent, uint64(st.WireLength))
+	case *stats.InPayload:
+		atomic.AddUint64(&h.bytesReceived, uint64(st.WireLength))
+	case *stats.End:
+		if st.Error != nil {
+			atomic.AddUint64(&h.failures, 1)
+		}
+	}
+}
+
+// TagConn returns the context unchanged
+func (h *statsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+// HandleConn processes the connection stats
+func (h *statsHandler) HandleConn(_ context.Context, s stats.ConnStats) {
+	if _, ok := s.(*stats.ConnBegin); ok {
+		atomic.AddUint64(&h.connections, 1)
+	}
+}
+
+func (h *statsHandler) snapshot() Stats {
+	return Stats{
+		Calls:         atomic.LoadUint64(&h.calls),
+		Failures:      atomic.LoadUint64(&h.failures),
+		BytesSent:     atomic.LoadUint64(&h.bytesSent),
+		BytesReceived: atomic.LoadUint64(&h.bytesReceived),
+		Connections:   atomic.LoadUint64(&h.connections),
+	}
+}
+
+// Stats returns the accumulated statistics of the client calls,
+// the statistics are collected only if Config.EnableStats is set
+func (c *Client) Stats() Stats {
+	if c.stats == nil {
+		return Stats{}
+	}
+	return c.stats.snapshot()
+}
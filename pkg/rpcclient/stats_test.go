@@ -0,0 +1,57 @@
+package rpcclient_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/effective-security/porto/pkg/rpcclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestStats(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+
+	hs := health.NewServer()
+	serv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(serv, hs)
+	go serv.Serve(lis)
+	defer serv.Stop()
+
+	client, err := rpcclient.New(&rpcclient.Config{
+		Endpoints:   []string{"http://" + lis.Addr().String()},
+		EnableStats: true,
+	})
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, rpcclient.Stats{}, client.Stats())
+
+	hc := grpc_health_v1.NewHealthClient(client.Conn())
+	_, err = hc.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	_, err = hc.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "unknown"})
+	require.Error(t, err)
+
+	st := client.Stats()
+	assert.Equal(t, uint64(2), st.Calls)
+	assert.Equal(t, uint64(1), st.Failures)
+	assert.Equal(t, uint64(1), st.Connections)
+	assert.NotZero(t, st.BytesSent)
+	assert.NotZero(t, st.BytesReceived)
+
+	// disabled by default
+	client2, err := rpcclient.New(&rpcclient.Config{
+		Endpoints: []string{"http://" + lis.Addr().String()},
+	})
+	require.NoError(t, err)
+	defer client2.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(client2.Conn()).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, rpcclient.Stats{}, client2.Stats())
+}
@@ -0,0 +1,70 @@
+package rpcclient
+
+import (
+	"context"
+
+	"github.com/effective-security/porto/xhttp/correlation"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// metadataCarrier adapts gRPC metadata to propagation.TextMapCarrier
+type metadataCarrier metadata.MD
+
+// Get returns the first value associated with the passed key.
+func (c metadataCarrier) Get(key string) string {
+	vals := metadata.MD(c).Get(key)
+	if len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// Set stores the key-value pair.
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+// Keys lists the keys stored in this carrier.
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// withTracingMetadata returns context with the trace context
+// and Correlation ID injected in the outgoing metadata
+func withTracingMetadata(ctx context.Context) context.Context {
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+
+	if cid := correlation.ID(ctx); cid != "" && len(md.Get(correlation.CorrelationIDgRPCHeaderName)) == 0 {
+		md.Set(correlation.CorrelationIDgRPCHeaderName, cid)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// newTracingUnaryInterceptor returns grpc.UnaryClientInterceptor that
+// propagates the trace context
+func newTracingUnaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(withTracingMetadata(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// newTracingStreamInterceptor returns grpc.StreamClientInterceptor that
+// propagates the trace context
+func newTracingStreamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withTracingMetadata(ctx), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,54 @@
+package rpcclient
+
+import (
+	"context"
+	"testing"
+
+	"github.com/effective-security/porto/xhttp/correlation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func Test_TracingInterceptor(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	ctx := correlation.WithID(context.Background())
+	ctx = trace.ContextWithSpanContext(ctx, sc)
+	cid := correlation.ID(ctx)
+
+	var md metadata.MD
+	unary := newTracingUnaryInterceptor()
+	err := unary(ctx, "/test", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, md.Get("traceparent"))
+	assert.Equal(t, []string{cid}, md.Get(correlation.CorrelationIDgRPCHeaderName))
+
+	stream := newTracingStreamInterceptor()
+	_, err = stream(metadata.AppendToOutgoingContext(ctx, "k1", "v1"), nil, nil, "/test",
+		func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return nil, nil
+		})
+	require.NoError(t, err)
+	assert.NotEmpty(t, md.Get("traceparent"))
+	assert.Equal(t, []string{"v1"}, md.Get("k1"))
+	assert.Equal(t, []string{cid}, md.Get(correlation.CorrelationIDgRPCHeaderName))
+}
@@ -0,0 +1,168 @@
+package tasks
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TaskBuilder provides fluent syntax to create a task:
+//
+//	tasks.NewTaskBuilder("name", fn).Every(5).Minutes()
+type TaskBuilder struct {
+	name      string
+	fn        interface{}
+	params    []interface{}
+	interval  uint64
+	after     []string
+	catchUp   int
+	weekdays  []time.Weekday
+	monthDays []int
+	err       error
+}
+
+// NewTaskBuilder returns a builder of the task,
+// that calls fn with params every time the task runs
+func NewTaskBuilder(name string, fn interface{}, params ...interface{}) *TaskBuilder {
+	b := &TaskBuilder{
+		name:   name,
+		fn:     fn,
+		params: params,
+	}
+
+	typ := reflect.TypeOf(fn)
+	if typ == nil || typ.Kind() != reflect.Func {
+		b.err = errors.Errorf("only function can be scheduled into the task queue")
+	} else if typ.NumIn() != len(params) {
+		b.err = errors.Errorf("the number of parameters does not match the function")
+	}
+	return b
+}
+
+// Every sets the interval between runs,
+// it must be followed by one of the time units
+func (b *TaskBuilder) Every(interval uint64) *TaskBuilder {
+	if interval < 1 && b.err == nil {
+		b.err = errors.Errorf("invalid interval: %d", interval)
+	}
+	b.interval = interval
+	return b
+}
+
+// After specifies the names of the tasks that this task depends on.
+// The task runs only after the dependencies have run successfully
+// since its own last run, and after the dependencies scheduled in the same tick.
+// The name is the one provided to NewTaskBuilder
+func (b *TaskBuilder) After(names ...string) *TaskBuilder {
+	b.after = append(b.after, names...)
+	return b
+}
+
+// CatchUp specifies to run the task once on the first tick,
+// if it missed runs by the wall clock, for example
+// when the system was asleep or the scheduler was paused.
+// Up to max missed runs are coalesced into the single run
+func (b *TaskBuilder) CatchUp(max int) *TaskBuilder {
+	b.catchUp = max
+	return b
+}
+
+// Weekday restricts the task to run only on the specified days of the week,
+// the next run is moved to the first matching day at the same time
+func (b *TaskBuilder) Weekday(days ...time.Weekday) *TaskBuilder {
+	for _, d := range days {
+		if (d < time.Sunday || d > time.Saturday) && b.err == nil {
+			b.err = errors.Errorf("invalid weekday value: %d", d)
+		}
+	}
+	b.weekdays = append(b.weekdays, days...)
+	return b
+}
+
+// Day restricts the task to run only on the specified days of the month,
+// the next run is moved to the first matching day at the same time.
+// If both Weekday and Day are specified, the day must match both
+func (b *TaskBuilder) Day(days ...int) *TaskBuilder {
+	for _, d := range days {
+		if (d < 1 || d > 31) && b.err == nil {
+			b.err = errors.Errorf("invalid day value: %d", d)
+		}
+	}
+	b.monthDays = append(b.monthDays, days...)
+	return b
+}
+
+// At returns the task to run once at the specified time,
+// see NewTaskAt
+func (b *TaskBuilder) At(at time.Time) (Task, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.weekdays) > 0 || len(b.monthDays) > 0 {
+		return nil, errors.Errorf("Weekday and Day are not supported for the one-time task")
+	}
+	t := NewTaskAt(at).(*task)
+	t.After(b.after...).Do(b.name, b.fn, b.params...)
+	return t, nil
+}
+
+// DailyAt returns the task to run daily at the specified time,
+// see NewTaskDaily
+func (b *TaskBuilder) DailyAt(hour, minute int) (Task, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return nil, errors.Errorf("invalid time value: time='%d:%d'", hour, minute)
+	}
+	return b.apply(NewTaskDaily(hour, minute).(*task)), nil
+}
+
+// Seconds returns the task to run every interval of seconds
+func (b *TaskBuilder) Seconds() (Task, error) {
+	return b.build(Seconds)
+}
+
+// Minutes returns the task to run every interval of minutes
+func (b *TaskBuilder) Minutes() (Task, error) {
+	return b.build(Minutes)
+}
+
+// Hours returns the task to run every interval of hours
+func (b *TaskBuilder) Hours() (Task, error) {
+	return b.build(Hours)
+}
+
+// Days returns the task to run every interval of days
+func (b *TaskBuilder) Days() (Task, error) {
+	return b.build(Days)
+}
+
+// Weeks returns the task to run every interval of weeks
+func (b *TaskBuilder) Weeks() (Task, error) {
+	return b.build(Weeks)
+}
+
+func (b *TaskBuilder) build(unit TimeUnit) (Task, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.interval == 0 {
+		return nil, errors.Errorf("Every must be called before the time unit")
+	}
+	return b.apply(NewTaskAtIntervals(b.interval, unit).(*task)), nil
+}
+
+// apply configures the task with the builder settings
+func (b *TaskBuilder) apply(t *task) *task {
+	t.After(b.after...).CatchUp(b.catchUp)
+	if len(b.weekdays) > 0 {
+		t.Weekday(b.weekdays...)
+	}
+	if len(b.monthDays) > 0 {
+		t.Day(b.monthDays...)
+	}
+	t.Do(b.name, b.fn, b.params...)
+	return t
+}
@@ -0,0 +1,105 @@
+package tasks
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TaskBuilder(t *testing.T) {
+	tcases := []struct {
+		build func(b *TaskBuilder) (Task, error)
+		exp   time.Duration
+	}{
+		{func(b *TaskBuilder) (Task, error) { return b.Every(30).Seconds() }, 30 * time.Second},
+		{func(b *TaskBuilder) (Task, error) { return b.Every(5).Minutes() }, 5 * time.Minute},
+		{func(b *TaskBuilder) (Task, error) { return b.Every(2).Hours() }, 2 * time.Hour},
+		{func(b *TaskBuilder) (Task, error) { return b.Every(1).Days() }, 24 * time.Hour},
+		{func(b *TaskBuilder) (Task, error) { return b.Every(1).Weeks() }, 7 * 24 * time.Hour},
+	}
+	for _, tc := range tcases {
+		task, err := tc.build(NewTaskBuilder("test", taskWithParams, 1, "hello"))
+		require.NoError(t, err)
+		assert.Equal(t, tc.exp, task.Duration())
+		assert.Equal(t, "test@tasks.taskWithParams", task.Name())
+		assert.Equal(t, task.LastRunTime().Add(tc.exp), task.NextScheduledTime())
+	}
+
+	at := time.Now().Add(time.Hour)
+	task, err := NewTaskBuilder("test", taskWithParams, 1, "hello").After("dep").At(at)
+	require.NoError(t, err)
+	assert.Equal(t, at, task.NextScheduledTime())
+	assert.Equal(t, []string{"dep"}, task.(DependentTask).Dependencies())
+
+	_, err = NewTaskBuilder("test", testTask).Weekday(time.Monday).At(at)
+	assert.EqualError(t, err, "Weekday and Day are not supported for the one-time task")
+
+	daily, err := NewTaskBuilder("test", testTask).Weekday(time.Monday).Day(1, 2, 3, 4, 5, 6, 7).DailyAt(9, 0)
+	require.NoError(t, err)
+	next := daily.NextScheduledTime()
+	assert.Equal(t, time.Monday, next.Weekday())
+	assert.LessOrEqual(t, next.Day(), 7)
+	assert.Equal(t, 9, next.Hour())
+
+	monthly, err := NewTaskBuilder("test", testTask).Every(1).Day(15).Days()
+	require.NoError(t, err)
+	assert.Equal(t, 15, monthly.NextScheduledTime().Day())
+
+	_, err = NewTaskBuilder("test", testTask).DailyAt(24, 0)
+	assert.EqualError(t, err, "invalid time value: time='24:0'")
+
+	_, err = NewTaskBuilder("test", testTask).Day(32).DailyAt(9, 0)
+	assert.EqualError(t, err, "invalid day value: 32")
+
+	_, err = NewTaskBuilder("test", testTask).Weekday(time.Weekday(7)).DailyAt(9, 0)
+	assert.EqualError(t, err, "invalid weekday value: 7")
+
+	_, err = NewTaskBuilder("test", "not a function").At(at)
+	assert.EqualError(t, err, "only function can be scheduled into the task queue")
+
+	_, err = NewTaskBuilder("test", testTask).Minutes()
+	assert.EqualError(t, err, "Every must be called before the time unit")
+
+	_, err = NewTaskBuilder("test", testTask).Every(0).Minutes()
+	assert.EqualError(t, err, "invalid interval: 0")
+
+	_, err = NewTaskBuilder("test", "not a function").Every(1).Minutes()
+	assert.EqualError(t, err, "only function can be scheduled into the task queue")
+
+	_, err = NewTaskBuilder("test", taskWithParams, 1).Every(1).Minutes()
+	assert.EqualError(t, err, "the number of parameters does not match the function")
+}
+
+func Test_TaskBuilderSchedule(t *testing.T) {
+	var lock sync.Mutex
+	count := 0
+	fn := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		count++
+	}
+
+	task, err := NewTaskBuilder("counter", fn).Every(1).Seconds()
+	require.NoError(t, err)
+
+	scheduler := NewScheduler(WithTickerInterval(100 * time.Millisecond))
+	defer scheduler.Stop()
+	scheduler.Add(task)
+	require.NoError(t, scheduler.Start())
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return count >= 1
+	}, 3*time.Second, 100*time.Millisecond)
+	require.NoError(t, scheduler.Stop())
+
+	assert.Eventually(t, func() bool {
+		return !task.(TaskStatus).IsRunning()
+	}, time.Second, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, task.RunCount(), uint32(1))
+	assert.False(t, task.LastRunTime().IsZero())
+}
@@ -0,0 +1,176 @@
+package tasks
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TaskConfig provides the declarative configuration of the task
+type TaskConfig struct {
+	// Name specifies the name of the task
+	Name string `json:"name" yaml:"name"`
+	// Func specifies the name of the function in the registry,
+	// by default it's the task Name
+	Func string `json:"func" yaml:"func"`
+	// Schedule specifies the schedule of the task, one of:
+	// the duration between runs, for example `90s` or `1h30m`;
+	// the cron expression `minute hour day month weekday`,
+	// for example `30 10 * * 1,5` or `*/5 * * * *`;
+	// or the format of NewTask, for example `every day 11:15`
+	Schedule string `json:"schedule" yaml:"schedule"`
+	// After specifies the names of the tasks that this task depends on
+	After []string `json:"after" yaml:"after"`
+}
+
+// NewFromConfig returns the scheduler with the tasks from the config,
+// the functions are looked up in the registry by TaskConfig.Func
+func NewFromConfig(cfg []TaskConfig, registry map[string]func(), ops ...Option) (Scheduler, error) {
+	s := NewScheduler(ops...)
+	for _, tc := range cfg {
+		t, err := newTaskFromConfig(&tc, registry)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid task %q", tc.Name)
+		}
+		s.Add(t)
+	}
+	return s, nil
+}
+
+func newTaskFromConfig(tc *TaskConfig, registry map[string]func()) (Task, error) {
+	if tc.Name == "" {
+		return nil, errors.Errorf("name is required")
+	}
+	fname := tc.Func
+	if fname == "" {
+		fname = tc.Name
+	}
+	fn := registry[fname]
+	if fn == nil {
+		return nil, errors.Errorf("function not found: %s", fname)
+	}
+
+	t, err := parseSchedule(tc.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	t.Do(tc.Name, fn)
+	if j, ok := t.(*task); ok && len(tc.After) > 0 {
+		j.After(tc.After...)
+	}
+	return t, nil
+}
+
+// cronField matches a field of the cron expression
+var cronField = regexp.MustCompile(`^[0-9*,/-]+$`)
+
+// parseSchedule returns the task for the duration,
+// cron expression, or the format of NewTask
+func parseSchedule(schedule string) (Task, error) {
+	schedule = strings.TrimSpace(schedule)
+	if schedule == "" {
+		return nil, errors.Errorf("schedule is required")
+	}
+	if d, err := time.ParseDuration(schedule); err == nil {
+		return taskFromDuration(d)
+	}
+	if fields := strings.Fields(schedule); len(fields) == 5 {
+		cron := true
+		for _, f := range fields {
+			cron = cron && cronField.MatchString(f)
+		}
+		if cron {
+			return taskFromCron(fields)
+		}
+	}
+	return NewTask(schedule)
+}
+
+// taskFromDuration returns the task to run every duration,
+// in the largest time unit that divides the duration
+func taskFromDuration(d time.Duration) (Task, error) {
+	if d < time.Second || d%time.Second != 0 {
+		return nil, errors.Errorf("duration must be a whole number of seconds: %s", d)
+	}
+	switch {
+	case d%time.Hour == 0:
+		return NewTaskAtIntervals(uint64(d/time.Hour), Hours), nil
+	case d%time.Minute == 0:
+		return NewTaskAtIntervals(uint64(d/time.Minute), Minutes), nil
+	default:
+		return NewTaskAtIntervals(uint64(d/time.Second), Seconds), nil
+	}
+}
+
+// taskFromCron returns the task for the cron expression,
+// the supported expressions are `*/N * * * *` to run every N minutes,
+// and the fixed minute and hour with the optional lists of
+// the days of the month or the days of the week
+func taskFromCron(fields []string) (Task, error) {
+	minute, hour, dom, month, dow := fields[0], fields[1], fields[2], fields[3], fields[4]
+	if month != "*" {
+		return nil, errors.Errorf("cron month is not supported: %s", month)
+	}
+
+	if strings.HasPrefix(minute, "*/") {
+		if hour != "*" || dom != "*" || dow != "*" {
+			return nil, errors.Errorf("cron minute step requires other fields to be *")
+		}
+		n, err := strconv.ParseUint(minute[2:], 10, 0)
+		if err != nil || n < 1 || n > 59 {
+			return nil, errors.Errorf("invalid cron minute: %s", minute)
+		}
+		return NewTaskAtIntervals(n, Minutes), nil
+	}
+
+	min, err := strconv.Atoi(minute)
+	if err != nil || min < 0 || min > 59 {
+		return nil, errors.Errorf("invalid cron minute: %s", minute)
+	}
+	h, err := strconv.Atoi(hour)
+	if err != nil || h < 0 || h > 23 {
+		return nil, errors.Errorf("invalid cron hour: %s", hour)
+	}
+	if dom != "*" && dow != "*" {
+		// cron runs on either day, while the task requires both to match
+		return nil, errors.Errorf("cron day of month and day of week are exclusive")
+	}
+
+	t := NewTaskDaily(h, min).(*task)
+	if dom != "*" {
+		days, err := parseCronList(dom, 1, 31)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid cron day of month")
+		}
+		t.Day(days...)
+	}
+	if dow != "*" {
+		days, err := parseCronList(dow, 0, 7)
+		if err != nil {
+			return nil, errors.WithMessage(err, "invalid cron day of week")
+		}
+		weekdays := make([]time.Weekday, len(days))
+		for i, d := range days {
+			// both 0 and 7 are Sunday
+			weekdays[i] = time.Weekday(d % 7)
+		}
+		t.Weekday(weekdays...)
+	}
+	return t, nil
+}
+
+// parseCronList returns the values of comma separated list
+func parseCronList(list string, min, max int) ([]int, error) {
+	var values []int
+	for _, s := range strings.Split(list, ",") {
+		v, err := strconv.Atoi(s)
+		if err != nil || v < min || v > max {
+			return nil, errors.Errorf("%q", s)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
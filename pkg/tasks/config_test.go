@@ -0,0 +1,95 @@
+package tasks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSchedule(t *testing.T) {
+	tcases := []struct {
+		schedule string
+		exp      time.Duration
+	}{
+		{"30s", 30 * time.Second},
+		{"90s", 90 * time.Second},
+		{"5m", 5 * time.Minute},
+		{"1h30m", 90 * time.Minute},
+		{"2h", 2 * time.Hour},
+		{"*/5 * * * *", 5 * time.Minute},
+		{"30 10 * * *", 24 * time.Hour},
+		{"every 10 seconds", 10 * time.Second},
+		{"every day 11:15", 24 * time.Hour},
+	}
+	for _, tc := range tcases {
+		j, err := parseSchedule(tc.schedule)
+		require.NoError(t, err, tc.schedule)
+		assert.Equal(t, tc.exp, j.Duration(), tc.schedule)
+	}
+
+	j, err := parseSchedule("30 10 1,15 * *")
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 15}, j.(*task).monthDays)
+
+	j, err = parseSchedule("30 10 * * 1,5,7")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Weekday{time.Monday, time.Friday, time.Sunday}, j.(*task).weekdays)
+	next := j.Do("test", testTask).NextScheduledTime()
+	assert.Equal(t, 10, next.Hour())
+	assert.Equal(t, 30, next.Minute())
+
+	errcases := []struct {
+		schedule string
+		err      string
+	}{
+		{"", "schedule is required"},
+		{"500ms", "duration must be a whole number of seconds: 500ms"},
+		{"-1m", "duration must be a whole number of seconds: -1m0s"},
+		{"30 10 * 1 *", "cron month is not supported: 1"},
+		{"*/5 10 * * *", "cron minute step requires other fields to be *"},
+		{"*/0 * * * *", "invalid cron minute: */0"},
+		{"60 10 * * *", "invalid cron minute: 60"},
+		{"30 */2 * * *", "invalid cron hour: */2"},
+		{"30 10 1 * 1", "cron day of month and day of week are exclusive"},
+		{"30 10 0 * *", "invalid cron day of month: \"0\""},
+		{"30 10 * * 1-5", "invalid cron day of week: \"1-5\""},
+		{"every fortnight", "task format not valid: \"every fortnight\""},
+	}
+	for _, tc := range errcases {
+		_, err := parseSchedule(tc.schedule)
+		assert.EqualError(t, err, tc.err, tc.schedule)
+	}
+}
+
+func Test_NewFromConfig(t *testing.T) {
+	registry := map[string]func(){
+		"collect":   testTask,
+		"aggregate": testTask,
+	}
+
+	s, err := NewFromConfig([]TaskConfig{
+		{Name: "collect", Schedule: "1m"},
+		{Name: "report", Func: "aggregate", Schedule: "0 6 * * 1", After: []string{"collect"}},
+	}, registry, WithTickerInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	assert.Equal(t, 2, s.Count())
+
+	tasks := s.(*scheduler).tasks
+	assert.Equal(t, "collect@tasks.testTask", tasks[0].Name())
+	assert.Equal(t, time.Minute, tasks[0].Duration())
+	assert.Equal(t, "report@tasks.testTask", tasks[1].Name())
+	assert.Equal(t, []string{"collect"}, tasks[1].(DependentTask).Dependencies())
+	require.NoError(t, s.Start())
+	require.NoError(t, s.Stop())
+
+	_, err = NewFromConfig([]TaskConfig{{Name: "missing", Schedule: "1m"}}, registry)
+	assert.EqualError(t, err, "invalid task \"missing\": function not found: missing")
+
+	_, err = NewFromConfig([]TaskConfig{{Schedule: "1m"}}, registry)
+	assert.EqualError(t, err, "invalid task \"\": name is required")
+
+	_, err = NewFromConfig([]TaskConfig{{Name: "collect", Schedule: "1ms"}}, registry)
+	assert.EqualError(t, err, "invalid task \"collect\": duration must be a whole number of seconds: 1ms")
+}
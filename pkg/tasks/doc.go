@@ -11,12 +11,29 @@
 	tasks.NewTaskAtIntervals(5, Minutes).Do(task)
 	tasks.NewTaskAtIntervals(8, Hours).Do(task)
 
+	// Do tasks with fluent syntax
+	j, err := tasks.NewTaskBuilder("name", task).Every(5).Minutes()
+
+	// Do tasks after another task completed successfully
+	j, err := tasks.NewTaskBuilder("aggregate", task).After("collect").Every(1).Hours()
+
 	// Do tasks on specific weekday
 	tasks.NewTaskOnWeekday(time.Monday, 23, 59).Do(task)
 
 	// Do tasks daily
 	tasks.NewTaskDaily(10,30).Do(task)
 
+	// Do tasks daily on specific weekdays or days of the month
+	j, err := tasks.NewTaskBuilder("name", task).Weekday(time.Monday, time.Friday).DailyAt(10, 30)
+	j, err := tasks.NewTaskBuilder("name", task).Day(1, 15).DailyAt(10, 30)
+
+	// Run once to catch up up to 3 missed runs, for example after the system sleep
+	j, err := tasks.NewTaskBuilder("name", task).CatchUp(3).Every(1).Hours()
+
+	// Do tasks once at specific time
+	tasks.NewTaskAt(time.Date(2025, time.June, 1, 9, 0, 0, 0, time.Local)).Do(task)
+	j, err := tasks.NewTaskBuilder("reminder", task).At(remindAt)
+
 	// Parse from string format
 	tasks.NewTask("16:18")
 	tasks.NewTask("every 1 second")
@@ -28,10 +45,40 @@
 
 	scheduler.Add(j)
 
+	// Read the outcome of the last run, for example in the health check
+	if j.LastError() != nil {
+		...
+	}
+
+	// Build the scheduler from the config, the functions are looked up by name
+	scheduler, err := tasks.NewFromConfig([]tasks.TaskConfig{
+		{Name: "cleanup", Schedule: "1h30m"},
+		{Name: "report", Schedule: "30 6 * * 1,5", After: []string{"cleanup"}},
+	}, map[string]func(){"cleanup": cleanup, "report": report})
+
+	// Run all tasks on Start, staggered within 1 minute
+	scheduler := tasks.NewScheduler(tasks.WithRunImmediately(), tasks.WithStartupJitter(time.Minute))
+
+	// Skip the one-time tasks, which time is already in the past on Start
+	scheduler := tasks.NewScheduler(tasks.WithSkipPastDue())
+
+	// Run the tasks one after another on a single goroutine,
+	// a long running task delays the other tasks
+	scheduler := tasks.NewScheduler(tasks.WithSequentialExecution())
+
+	// Run the task on the next tick, for example when added to the running scheduler
+	scheduler.AddNow(j)
+
 	// Start the scheduler
 	scheduler.Start()
 
-	// Stop the scheduler
+	// Suspend the tasks runs for maintenance, the missed runs are skipped,
+	// unless the scheduler is created with WithCatchUpOnResume option
+	scheduler.Pause()
+	scheduler.Resume()
+
+	// Stop the scheduler, and wait for the scheduler loop to exit,
+	// the callback provided by WithOnStop option is invoked once
 	scheduler.Stop()
 */
 package tasks
@@ -5,10 +5,14 @@
 package tasks
 
 import (
+	"math/rand"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/effective-security/porto/metricskey"
 	"github.com/effective-security/xlog"
 	"github.com/pkg/errors"
 )
@@ -18,6 +22,9 @@ var logger = xlog.NewPackageLogger("github.com/effective-security/porto/pkg", "t
 // DefaultTickerInterval for scheduler
 const DefaultTickerInterval = time.Second
 
+// DefaultSchedulerName is the `scheduler` tag of the published metrics
+const DefaultSchedulerName = "default"
+
 // Time location, default set by the time.Local (*time.Location)
 var loc = time.Local
 
@@ -28,28 +35,77 @@ func SetGlobalLocation(newLocation *time.Location) {
 
 // Scheduler defines the scheduler interface
 type Scheduler interface {
-	// Add adds a task to a pool of scheduled tasks
+	// Add adds a task to a pool of scheduled tasks,
+	// the task can be created by NewTaskBuilder, NewTask or NewTaskAtIntervals,
+	// or be a custom implementation of Task interface.
+	// The custom task may implement the optional interfaces:
+	// TaskStatus, StatefulTask, DependentTask and MissedRunsSkipper
 	Add(Task) Scheduler
+	// AddNow adds a task to a pool of scheduled tasks,
+	// and runs it on the next tick regardless of its scheduled time
+	AddNow(Task) Scheduler
 	// Clear will delete all scheduled tasks
 	Clear()
 	// Count returns the number of registered tasks
 	Count() int
 	// IsRunning return the status
 	IsRunning() bool
+	// Pause suspends the tasks runs, the scheduler keeps ticking
+	// and the tasks state is preserved
+	Pause()
+	// Resume resumes the tasks runs after Pause
+	Resume()
+	// IsPaused returns true if the scheduler is paused
+	IsPaused() bool
+	// Metrics returns the snapshot of the scheduler metrics
+	Metrics() SchedulerMetrics
+	// PublishMetrics emits the scheduler metrics as gauges,
+	// tagged with the scheduler name
+	PublishMetrics()
 	// Start all the pending tasks
 	Start() error
 	// Stop the scheduler
 	Stop() error
 }
 
+// SchedulerMetrics provides the aggregated metrics of the scheduled tasks
+type SchedulerMetrics struct {
+	// Tasks is the number of registered tasks
+	Tasks int
+	// Running is the number of tasks running now
+	Running int
+	// Runs is the total number of the tasks runs by the scheduler,
+	// including the removed tasks
+	Runs uint64
+	// Failures is the total number of the failed runs by the scheduler
+	Failures uint64
+}
+
+// immediateRun is the task to run on the first tick after the time,
+// regardless of its scheduled time
+type immediateRun struct {
+	task Task
+	at   time.Time
+}
+
 // scheduler provides a task scheduler functionality
 type scheduler struct {
 	dops options
 
-	tasks   []Task
-	running bool
-	quit    chan bool
-	lock    sync.RWMutex
+	tasks []Task
+	// immediate tasks to run regardless of the scheduled time
+	immediate []immediateRun
+	running   bool
+	paused    bool
+	quit      chan bool
+	// stopped is closed when the scheduler loop exits
+	stopped chan struct{}
+	// inSequentialRun is set while the tasks run on the scheduler loop
+	inSequentialRun atomic.Bool
+	// runs and failures are the cumulative counters of the tasks runs
+	runs     atomic.Uint64
+	failures atomic.Uint64
+	lock     sync.RWMutex
 }
 
 // Scheduler implements the sort.Interface{} for sorting tasks, by the time nextRun
@@ -81,12 +137,20 @@ func NewScheduler(ops ...Option) Scheduler {
 	for _, op := range ops {
 		op.apply(&s.dops)
 	}
+	if s.dops.name == "" {
+		s.dops.name = DefaultSchedulerName
+	}
+	if s.dops.stateStore == nil {
+		s.dops.stateStore = NewNopStateStore()
+	}
 
 	return s
 }
 
 // Count returns the number of registered tasks
 func (s *scheduler) Count() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 	return len(s.tasks)
 }
 
@@ -104,15 +168,26 @@ func (s *scheduler) getRunnableTasks() []Task {
 			break
 		}
 	}
+	now := time.Now()
+	var pending []immediateRun
+	for _, r := range s.immediate {
+		if r.at.After(now) {
+			pending = append(pending, r)
+		} else if !r.task.ShouldRun() {
+			runnable = append(runnable, r.task)
+		}
+	}
+	s.immediate = pending
 	return runnable
 }
 
-// Get the current runnable tasks, which shouldRun is True
+// getAllTasks returns a copy of the scheduled tasks,
+// as the tasks are sorted and removed in place on the next ticks
 func (s *scheduler) getAllTasks() []Task {
-	s.lock.Lock()
-	defer s.lock.Unlock()
+	s.lock.RLock()
+	defer s.lock.RUnlock()
 
-	return s.tasks[:]
+	return append([]Task(nil), s.tasks...)
 }
 
 // Add adds a task to a pool of scheduled tasks
@@ -124,11 +199,234 @@ func (s *scheduler) Add(j Task) Scheduler {
 	return s
 }
 
+// AddNow adds a task to a pool of scheduled tasks,
+// and runs it on the next tick regardless of its scheduled time
+func (s *scheduler) AddNow(j Task) Scheduler {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tasks = append(s.tasks, j)
+	s.immediate = append(s.immediate, immediateRun{task: j})
+	return s
+}
+
+// remove deletes the task from the pool of scheduled tasks
+func (s *scheduler) remove(j Task) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, t := range s.tasks {
+		if t == j {
+			s.tasks = append(s.tasks[:i], s.tasks[i+1:]...)
+			break
+		}
+	}
+}
+
 // runPending will run all the tasks that are scheduled to run.
+// The tasks with dependencies wait for the dependencies,
+// that are scheduled in the same tick, to complete
 func (s *scheduler) runPending() {
-	for _, task := range s.getRunnableTasks() {
+	if s.IsPaused() {
+		return
+	}
+	runnable := s.getRunnableTasks()
+	if s.dops.sequential {
+		s.runSequential(runnable)
+		return
+	}
+
+	done := make(map[Task]chan struct{}, len(runnable))
+	for _, task := range runnable {
+		done[task] = make(chan struct{})
+	}
+
+	for _, task := range runnable {
+		var wait []chan struct{}
+		for _, name := range dependencies(task) {
+			for _, dep := range runnable {
+				if matchName(dep, name) {
+					wait = append(wait, done[dep])
+				}
+			}
+		}
+
 		logger.KV(xlog.DEBUG, "status", "pending_run", "task", task.Name())
-		go task.Run()
+		go func(task Task, wait []chan struct{}) {
+			defer close(done[task])
+			for _, ch := range wait {
+				<-ch
+			}
+			s.runTask(task)
+		}(task, wait)
+	}
+}
+
+// runSequential runs the tasks one after another on the calling goroutine,
+// in the order of the next run. The task with dependencies,
+// that are scheduled in the same tick, runs after the dependencies
+func (s *scheduler) runSequential(runnable []Task) {
+	s.inSequentialRun.Store(true)
+	defer s.inSequentialRun.Store(false)
+
+	done := make(map[Task]bool, len(runnable))
+	for len(done) < len(runnable) {
+		progress := false
+		for _, task := range runnable {
+			if done[task] || !dependenciesDone(task, runnable, done) {
+				continue
+			}
+			logger.KV(xlog.DEBUG, "status", "pending_run", "task", task.Name())
+			s.runTask(task)
+			done[task] = true
+			progress = true
+		}
+		if !progress {
+			// not expected, as the cyclic dependencies are rejected on Start
+			return
+		}
+	}
+}
+
+// dependenciesDone returns true if the dependencies of the task,
+// that are in the runnable list, are done
+func dependenciesDone(t Task, runnable []Task, done map[Task]bool) bool {
+	for _, name := range dependencies(t) {
+		for _, dep := range runnable {
+			if matchName(dep, name) && !done[dep] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// runTask runs the task if its dependencies succeeded,
+// and saves the state after the run
+func (s *scheduler) runTask(task Task) {
+	if !s.dependenciesSucceeded(task) {
+		logger.KV(xlog.DEBUG, "status", "waiting_dependencies", "task", task.Name())
+		return
+	}
+	if task.Run() {
+		s.runs.Add(1)
+		if task.LastError() != nil {
+			s.failures.Add(1)
+		}
+		s.saveState(task)
+		if isDone(task) {
+			s.remove(task)
+		}
+	}
+}
+
+// dependenciesSucceeded returns true if all dependencies of the task
+// have run successfully since the last run of the task
+func (s *scheduler) dependenciesSucceeded(t Task) bool {
+	deps := dependencies(t)
+	if len(deps) == 0 {
+		return true
+	}
+
+	lastRun := t.LastRunTime()
+	for _, dep := range s.getAllTasks() {
+		for _, name := range deps {
+			if matchName(dep, name) && !lastSuccessTime(dep).After(lastRun) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// dependencies returns the names of the tasks that the task depends on,
+// if it implements DependentTask
+func dependencies(t Task) []string {
+	if d, ok := t.(DependentTask); ok {
+		return d.Dependencies()
+	}
+	return nil
+}
+
+// lastSuccessTime returns the time of last successful run,
+// or the time of last run if the task does not implement TaskStatus
+func lastSuccessTime(t Task) time.Time {
+	if st, ok := t.(TaskStatus); ok {
+		return st.LastSuccessTime()
+	}
+	return t.LastRunTime()
+}
+
+// checkDependencies returns error if a dependency is not found,
+// or the dependencies are cyclic
+func (s *scheduler) checkDependencies() error {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[Task]int, len(s.tasks))
+
+	var visit func(t Task, path []string) error
+	visit = func(t Task, path []string) error {
+		path = append(path, t.Name())
+		switch state[t] {
+		case visiting:
+			return errors.Errorf("cyclic dependency: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[t] = visiting
+		for _, name := range dependencies(t) {
+			found := false
+			for _, dep := range s.tasks {
+				if matchName(dep, name) {
+					found = true
+					if err := visit(dep, path); err != nil {
+						return err
+					}
+				}
+			}
+			if !found {
+				return errors.Errorf("dependency not found: %s, task: %s", name, t.Name())
+			}
+		}
+		state[t] = visited
+		return nil
+	}
+
+	for _, t := range s.tasks {
+		if err := visit(t, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreState restores the time of last run for the tasks
+// from the state store
+func (s *scheduler) restoreState() {
+	for _, t := range s.tasks {
+		lastRun, err := s.dops.stateStore.LastRunTime(t.Name())
+		if err != nil {
+			logger.KV(xlog.ERROR, "reason", "restore_state", "task", t.Name(), "err", err.Error())
+			continue
+		}
+		if st, ok := t.(StatefulTask); ok && !lastRun.IsZero() {
+			st.SetLastRunTime(lastRun)
+			logger.KV(xlog.DEBUG,
+				"status", "restored",
+				"task", t.Name(),
+				"last_run", lastRun,
+				"next_run", t.NextScheduledTime())
+		}
+	}
+}
+
+// saveState persists the time of last run for the task
+func (s *scheduler) saveState(t Task) {
+	err := s.dops.stateStore.SetLastRunTime(t.Name(), t.LastRunTime())
+	if err != nil {
+		logger.KV(xlog.ERROR, "reason", "save_state", "task", t.Name(), "err", err.Error())
 	}
 }
 
@@ -137,6 +435,7 @@ func (s *scheduler) Clear() {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	s.tasks = []Task{}
+	s.immediate = nil
 }
 
 // IsRunning return the status
@@ -146,6 +445,74 @@ func (s *scheduler) IsRunning() bool {
 	return s.running
 }
 
+// Pause suspends the tasks runs, the scheduler keeps ticking
+// and the tasks state is preserved
+func (s *scheduler) Pause() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paused = true
+}
+
+// Resume resumes the tasks runs after Pause.
+// The runs missed during the pause are skipped,
+// unless the scheduler is created with WithCatchUpOnResume option,
+// or the task is configured with TaskBuilder.CatchUp.
+// The one-time tasks and the custom implementations of Task interface,
+// that do not implement MissedRunsSkipper, run on the first tick after Resume
+func (s *scheduler) Resume() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.paused {
+		return
+	}
+	s.paused = false
+
+	if !s.dops.catchUpOnResume {
+		now := time.Now()
+		for _, t := range s.tasks {
+			if sk, ok := t.(MissedRunsSkipper); ok {
+				sk.SkipMissed(now)
+			}
+		}
+	}
+}
+
+// IsPaused returns true if the scheduler is paused
+func (s *scheduler) IsPaused() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.paused
+}
+
+// Metrics returns the snapshot of the scheduler metrics
+func (s *scheduler) Metrics() SchedulerMetrics {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	m := SchedulerMetrics{
+		Tasks:    len(s.tasks),
+		Runs:     s.runs.Load(),
+		Failures: s.failures.Load(),
+	}
+	for _, t := range s.tasks {
+		if st, ok := t.(TaskStatus); ok && st.IsRunning() {
+			m.Running++
+		}
+	}
+	return m
+}
+
+// PublishMetrics emits the scheduler metrics as gauges,
+// tagged with the scheduler name
+func (s *scheduler) PublishMetrics() {
+	m := s.Metrics()
+	name := s.dops.name
+	metricskey.TasksCount.SetGauge(float64(m.Tasks), name)
+	metricskey.TasksRunning.SetGauge(float64(m.Running), name)
+	metricskey.TasksRuns.SetGauge(float64(m.Runs), name)
+	metricskey.TasksFailures.SetGauge(float64(m.Failures), name)
+}
+
 // Start all the pending tasks,
 // and create a second ticker
 func (s *scheduler) Start() error {
@@ -154,15 +521,45 @@ func (s *scheduler) Start() error {
 	if s.running {
 		return errors.Errorf("schedule already started")
 	}
+	if err := s.checkDependencies(); err != nil {
+		return err
+	}
 	s.running = true
 
+	s.restoreState()
+
+	now := time.Now()
+	tasks := s.tasks[:0]
+	for _, t := range s.tasks {
+		if isOneTime(t) && (isDone(t) || (s.dops.skipPastDue && t.NextScheduledTime().Before(now))) {
+			logger.KV(xlog.DEBUG, "status", "skipped", "task", t.Name())
+			continue
+		}
+		tasks = append(tasks, t)
+	}
+	s.tasks = tasks
+
+	if s.dops.runImmediately {
+		for _, t := range s.tasks {
+			if isOneTime(t) {
+				// the one-time task runs at its time
+				continue
+			}
+			at := now
+			if s.dops.startupJitter > 0 {
+				at = at.Add(time.Duration(rand.Int63n(int64(s.dops.startupJitter))))
+			}
+			s.immediate = append(s.immediate, immediateRun{task: t, at: at})
+		}
+	}
+
 	interval := s.dops.tickerInterval
 	if interval == 0 {
 		// if not specified, then find a reasonable interval to schedule
 		interval = DefaultTickerInterval
 		for _, t := range s.tasks {
 			in := t.Duration()
-			if in < interval {
+			if in > 0 && in < interval {
 				interval = in / 10 // use 1/10 of a task schedule interval
 			}
 		}
@@ -173,12 +570,20 @@ func (s *scheduler) Start() error {
 	}
 
 	logger.KV(xlog.DEBUG,
-		"tasks", s.Count(),
+		"tasks", len(s.tasks),
 		"schedule_interval", interval,
 	)
 
 	ticker := time.NewTicker(interval)
+	stopped := make(chan struct{})
+	s.stopped = stopped
 	go func() {
+		defer func() {
+			if s.dops.onStop != nil {
+				s.dops.onStop()
+			}
+			close(stopped)
+		}()
 		for {
 			select {
 			case <-ticker.C:
@@ -193,16 +598,31 @@ func (s *scheduler) Start() error {
 	return nil
 }
 
-// Stop the scheduler
+// Stop the scheduler,
+// and wait for the scheduler loop to exit.
+// With WithSequentialExecution option, if the tasks are running,
+// Stop does not wait, as it may be called by the task on the scheduler loop
 func (s *scheduler) Stop() error {
 	s.lock.Lock()
-	defer s.lock.Unlock()
 	if !s.running {
+		s.lock.Unlock()
 		return errors.Errorf("the scheduler is not running")
 	}
+	select {
+	case s.quit <- true:
+	default:
+		// already stopping
+	}
+	stopped := s.stopped
+	s.lock.Unlock()
 
-	s.quit <- true
+	if s.dops.sequential && s.inSequentialRun.Load() {
+		// waiting for the loop, that runs the caller, would deadlock
+		return nil
+	}
 
+	// the lock is released, as the pending run may need it
+	<-stopped
 	return nil
 }
 
@@ -212,7 +632,17 @@ type Option interface {
 }
 
 type options struct {
+	name           string
 	tickerInterval time.Duration
+	stateStore     StateStore
+	onStop         func()
+	runImmediately bool
+	startupJitter  time.Duration
+	skipPastDue    bool
+	// run the tasks missed during the pause
+	catchUpOnResume bool
+	// run the tasks on the scheduler goroutine
+	sequential bool
 }
 
 type funcOption struct {
@@ -229,9 +659,84 @@ func newFuncOption(f func(*options)) *funcOption {
 	}
 }
 
+// WithName option to provide the scheduler name,
+// that tags the published metrics, by default DefaultSchedulerName
+func WithName(name string) Option {
+	return newFuncOption(func(o *options) {
+		o.name = name
+	})
+}
+
 // WithTickerInterval option to provide ticker interval
 func WithTickerInterval(tickerInterval time.Duration) Option {
 	return newFuncOption(func(o *options) {
 		o.tickerInterval = tickerInterval
 	})
 }
+
+// WithStateStore option to provide the store to persist the tasks state.
+// The time of last run is restored on Start,
+// and saved after each run
+func WithStateStore(store StateStore) Option {
+	return newFuncOption(func(o *options) {
+		o.stateStore = store
+	})
+}
+
+// WithOnStop option to provide a callback,
+// that is invoked once after the scheduler loop exits
+func WithOnStop(onStop func()) Option {
+	return newFuncOption(func(o *options) {
+		o.onStop = onStop
+	})
+}
+
+// WithRunImmediately option to run all the tasks on the first tick after Start,
+// regardless of their scheduled time.
+// See WithStartupJitter to stagger the initial runs
+func WithRunImmediately() Option {
+	return newFuncOption(func(o *options) {
+		o.runImmediately = true
+	})
+}
+
+// WithStartupJitter option to stagger the initial runs of WithRunImmediately,
+// each task runs after a random delay within the window
+func WithStartupJitter(window time.Duration) Option {
+	return newFuncOption(func(o *options) {
+		o.startupJitter = window
+	})
+}
+
+// WithSkipPastDue option to skip the one-time tasks created by NewTaskAt,
+// if their time is already in the past on Start.
+// By default, such tasks run on the first tick after Start
+func WithSkipPastDue() Option {
+	return newFuncOption(func(o *options) {
+		o.skipPastDue = true
+	})
+}
+
+// WithCatchUpOnResume option to run the tasks, that missed their runs
+// during the pause, on the first tick after Resume.
+// Each task runs once regardless of the number of missed runs
+func WithCatchUpOnResume() Option {
+	return newFuncOption(func(o *options) {
+		o.catchUpOnResume = true
+	})
+}
+
+// WithSequentialExecution option to run the tasks one after another
+// on the scheduler goroutine, in the order of the next run,
+// instead of a goroutine per task run.
+// It reduces the number of goroutines on the constrained devices,
+// but a long running task delays the other tasks, and the ticks
+// are skipped while the tasks are running.
+// Stop waits for the running tasks to complete, unless it is called
+// while the tasks are running, for example by the task itself,
+// use WithOnStop to be notified when the scheduler loop exits
+func WithSequentialExecution() Option {
+	return newFuncOption(func(o *options) {
+		o.sequential = true
+	})
+}
@@ -1,10 +1,16 @@
 package tasks
 
 import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/effective-security/metrics"
 	"github.com/effective-security/xlog"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -37,7 +43,7 @@ func Test_StartAndStop(t *testing.T) {
 	tasks := scheduler.getAllTasks()
 	assert.Equal(t, 2, len(tasks))
 	for _, j := range tasks {
-		assert.False(t, j.(*task).running)
+		assert.False(t, j.(*task).running.Load())
 		count := j.RunCount()
 		assert.True(t, count >= 3, "Expected retry count >= 3, actual %d, name: %s", count, j.Name())
 	}
@@ -58,3 +64,435 @@ func Test_AddAndClear(t *testing.T) {
 	scheduler.Clear()
 	assert.Equal(t, 0, scheduler.Count())
 }
+
+func Test_AddNow(t *testing.T) {
+	scheduler := NewScheduler(WithTickerInterval(10 * time.Millisecond))
+	defer scheduler.Stop()
+	require.NoError(t, scheduler.Start())
+
+	added := NewTaskAtIntervals(1, Hours).Do("added", testTask)
+	now := NewTaskAtIntervals(1, Hours).Do("now", testTask)
+	scheduler.Add(added).AddNow(now)
+
+	assert.Eventually(t, func() bool {
+		return now.RunCount() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint32(0), added.RunCount())
+
+	// the task is rescheduled after the run
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint32(1), now.RunCount())
+	assert.True(t, now.NextScheduledTime().After(time.Now().Add(59*time.Minute)))
+}
+
+func Test_RunImmediately(t *testing.T) {
+	t1 := NewTaskAtIntervals(1, Hours).Do("t1", testTask)
+	t2 := NewTaskAtIntervals(1, Hours).Do("t2", testTask)
+
+	scheduler := NewScheduler(
+		WithTickerInterval(10*time.Millisecond),
+		WithRunImmediately(),
+	)
+	defer scheduler.Stop()
+	scheduler.Add(t1).Add(t2)
+	require.NoError(t, scheduler.Start())
+
+	assert.Eventually(t, func() bool {
+		return t1.RunCount() == 1 && t2.RunCount() == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func Test_StartupJitter(t *testing.T) {
+	t1 := NewTaskAtIntervals(1, Hours).Do("t1", testTask)
+	t2 := NewTaskAtIntervals(1, Hours).Do("t2", testTask)
+
+	s := NewScheduler(
+		WithTickerInterval(10*time.Millisecond),
+		WithRunImmediately(),
+		WithStartupJitter(time.Hour),
+	).(*scheduler)
+	defer s.Stop()
+	s.Add(t1).Add(t2)
+
+	started := time.Now()
+	require.NoError(t, s.Start())
+
+	s.lock.RLock()
+	require.Len(t, s.immediate, 2)
+	for _, r := range s.immediate {
+		assert.False(t, r.at.Before(started))
+		assert.True(t, r.at.Before(started.Add(time.Hour)))
+	}
+	s.lock.RUnlock()
+
+	// the initial runs are delayed
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, uint32(0), t1.RunCount()+t2.RunCount())
+}
+
+func Test_TaskAtSchedule(t *testing.T) {
+	past := NewTaskAt(time.Now().Add(-time.Hour)).Do("past", testTask)
+	soon := NewTaskAt(time.Now().Add(50*time.Millisecond)).Do("soon", testTask)
+	later := NewTaskAt(time.Now().Add(time.Hour)).Do("later", testTask)
+
+	scheduler := NewScheduler(
+		WithTickerInterval(10*time.Millisecond),
+		WithRunImmediately(),
+	)
+	defer scheduler.Stop()
+	scheduler.Add(past).Add(soon).Add(later)
+	require.NoError(t, scheduler.Start())
+
+	// the tasks are removed after the run
+	assert.Eventually(t, func() bool {
+		return scheduler.Count() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint32(1), past.RunCount())
+	assert.Equal(t, uint32(1), soon.RunCount())
+	assert.Equal(t, uint32(0), later.RunCount())
+}
+
+func Test_SkipPastDue(t *testing.T) {
+	past := NewTaskAt(time.Now().Add(-time.Hour)).Do("past", testTask)
+	later := NewTaskAt(time.Now().Add(time.Hour)).Do("later", testTask)
+
+	scheduler := NewScheduler(
+		WithTickerInterval(10*time.Millisecond),
+		WithSkipPastDue(),
+	)
+	defer scheduler.Stop()
+	scheduler.Add(past).Add(later)
+	require.NoError(t, scheduler.Start())
+	assert.Equal(t, 1, scheduler.Count())
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, uint32(0), past.RunCount())
+}
+
+func Test_PauseResume(t *testing.T) {
+	t1 := NewTaskAtIntervals(1, Seconds).Do("t1", testTask)
+
+	s := NewScheduler(WithTickerInterval(10 * time.Millisecond))
+	defer s.Stop()
+	s.Add(t1)
+	assert.False(t, s.IsPaused())
+
+	s.Pause()
+	assert.True(t, s.IsPaused())
+	require.NoError(t, s.Start())
+
+	// the ticks are observed, but the tasks are not run
+	time.Sleep(1200 * time.Millisecond)
+	assert.Equal(t, uint32(0), t1.RunCount())
+
+	// the missed run is skipped
+	s.Resume()
+	assert.False(t, s.IsPaused())
+	assert.True(t, t1.NextScheduledTime().After(time.Now()))
+	assert.Eventually(t, func() bool {
+		return t1.RunCount() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+type skipperTask struct {
+	Task
+	skipped int32
+}
+
+func (t *skipperTask) SkipMissed(now time.Time) {
+	atomic.AddInt32(&t.skipped, 1)
+}
+
+func Test_ResumeCustomTask(t *testing.T) {
+	custom := &skipperTask{Task: NewTaskAtIntervals(1, Hours).Do("custom", testTask)}
+
+	s := NewScheduler(WithTickerInterval(10 * time.Millisecond))
+	defer s.Stop()
+	s.Add(custom)
+	s.Pause()
+	require.NoError(t, s.Start())
+
+	s.Resume()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&custom.skipped))
+
+	// not paused
+	s.Resume()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&custom.skipped))
+}
+
+func Test_CatchUpOnResume(t *testing.T) {
+	t1 := NewTaskAtIntervals(1, Hours).Do("t1", testTask).(*task)
+	t1.SetLastRunTime(time.Now().Add(-3 * time.Hour))
+
+	s := NewScheduler(
+		WithTickerInterval(10*time.Millisecond),
+		WithCatchUpOnResume(),
+	)
+	defer s.Stop()
+	s.Add(t1)
+	s.Pause()
+	require.NoError(t, s.Start())
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, uint32(0), t1.RunCount())
+
+	// the missed runs are not backfilled
+	s.Resume()
+	assert.Eventually(t, func() bool {
+		return t1.RunCount() == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, uint32(1), t1.RunCount())
+}
+
+func Test_OnStop(t *testing.T) {
+	var stops int32
+	scheduler := NewScheduler(
+		WithTickerInterval(10*time.Millisecond),
+		WithOnStop(func() {
+			atomic.AddInt32(&stops, 1)
+		}),
+	)
+	assert.EqualError(t, scheduler.Stop(), "the scheduler is not running")
+
+	scheduler.Add(NewTaskAtIntervals(1, Hours).Do("test", testTask))
+	require.NoError(t, scheduler.Start())
+	time.Sleep(30 * time.Millisecond)
+
+	// Stop returns after the loop exited
+	require.NoError(t, scheduler.Stop())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&stops))
+
+	require.NoError(t, scheduler.Stop())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&stops))
+}
+
+func Test_Metrics(t *testing.T) {
+	im := metrics.NewInmemSink(time.Minute, time.Minute)
+	_, err := metrics.NewGlobal(metrics.DefaultConfig("test"), im)
+	require.NoError(t, err)
+
+	scheduler := NewScheduler(WithName("jobs")).(*scheduler)
+	assert.Equal(t, SchedulerMetrics{}, scheduler.Metrics())
+
+	release := make(chan struct{})
+	blocking := NewTaskAtIntervals(1, Hours).Do("blocking", func() {
+		<-release
+	}).(*task)
+	failing := NewTaskAtIntervals(1, Hours).Do("failing", func() error {
+		return errors.New("failed")
+	}).(*task)
+	once := NewTaskAt(time.Now()).Do("once", testTask)
+	scheduler.Add(blocking).Add(failing).Add(once)
+
+	scheduler.runTask(failing)
+	scheduler.runTask(failing)
+	assert.Equal(t, uint32(2), failing.FailureCount())
+	// the one-time task is removed after the run
+	scheduler.runTask(once)
+
+	go blocking.Run()
+	assert.Eventually(t, blocking.IsRunning, time.Second, 10*time.Millisecond)
+
+	m := scheduler.Metrics()
+	assert.Equal(t, SchedulerMetrics{
+		Tasks:    2,
+		Running:  1,
+		Runs:     3,
+		Failures: 2,
+	}, m)
+	scheduler.PublishMetrics()
+
+	gauges := im.Data()[0].Gauges
+	for _, key := range []string{
+		"test_tasks_count;scheduler=jobs",
+		"test_tasks_running;scheduler=jobs",
+		"test_tasks_runs;scheduler=jobs",
+		"test_tasks_failures;scheduler=jobs",
+	} {
+		assert.Contains(t, gauges, key)
+	}
+	assert.Equal(t, float64(3), gauges["test_tasks_runs;scheduler=jobs"].Value)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return scheduler.Metrics().Running == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// plainTask provides only the methods of Task interface
+type plainTask struct {
+	Task
+}
+
+func Test_CustomTask(t *testing.T) {
+	var _ TaskStatus = (*task)(nil)
+	var _ StatefulTask = (*task)(nil)
+	var _ DependentTask = (*task)(nil)
+	var _ MissedRunsSkipper = (*task)(nil)
+
+	custom := plainTask{Task: NewTaskAtIntervals(1, Hours).Do("custom", testTask)}
+	require.True(t, custom.Run())
+
+	store, err := NewFileStateStore(filepath.Join(t.TempDir(), "state.json"))
+	require.NoError(t, err)
+	require.NoError(t, store.SetLastRunTime(custom.Name(), time.Now().Add(-2*time.Hour)))
+
+	s := NewScheduler(WithStateStore(store)).(*scheduler)
+	s.Add(custom)
+	s.restoreState()
+	assert.Nil(t, dependencies(custom))
+	assert.Equal(t, custom.LastRunTime(), lastSuccessTime(custom))
+	s.runTask(custom)
+	assert.Equal(t, SchedulerMetrics{Tasks: 1, Runs: 1}, s.Metrics())
+}
+
+func Test_Dependencies(t *testing.T) {
+	var lock sync.Mutex
+	var calls []string
+	called := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, calls...)
+	}
+	record := func(name string, err error) func() error {
+		return func() error {
+			time.Sleep(20 * time.Millisecond)
+			lock.Lock()
+			calls = append(calls, name)
+			lock.Unlock()
+			return err
+		}
+	}
+
+	past := time.Now().Add(-2 * time.Hour)
+	collect := NewTaskAtIntervals(1, Hours).Do("collect", record("collect", nil)).(*task).SetLastRunTime(past)
+	aggregate, err := NewTaskBuilder("aggregate", record("aggregate", nil)).Every(1).Hours()
+	require.NoError(t, err)
+	aggregate.(*task).After("collect").SetLastRunTime(past)
+	assert.Equal(t, []string{"collect"}, aggregate.(DependentTask).Dependencies())
+
+	s := NewScheduler().(*scheduler)
+	s.Add(aggregate).Add(collect)
+	require.NoError(t, s.checkDependencies())
+
+	s.runPending()
+	assert.Eventually(t, func() bool {
+		return len(called()) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"collect", "aggregate"}, called())
+
+	// dependency failed
+	lock.Lock()
+	calls = nil
+	lock.Unlock()
+	failing := NewTaskAtIntervals(1, Hours).Do("failing", record("failing", errors.New("failed"))).(*task).SetLastRunTime(past)
+	dependent := NewTaskAtIntervals(1, Hours).Do("dependent", record("dependent", nil)).(*task).After("failing").SetLastRunTime(past)
+	s.Clear()
+	s.Add(failing).Add(dependent)
+
+	s.runPending()
+	assert.Eventually(t, func() bool {
+		return len(called()) == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"failing"}, called())
+	assert.Equal(t, uint32(0), dependent.RunCount())
+	assert.True(t, dependent.ShouldRun())
+}
+
+func Test_DependenciesAcrossTicks(t *testing.T) {
+	past := time.Now().Add(-2 * time.Hour)
+	collect := NewTaskAtIntervals(1, Hours).Do("collect", func() error {
+		return errors.New("failed")
+	}).(*task).SetLastRunTime(past)
+	aggregate := NewTaskAtIntervals(1, Hours).Do("aggregate", testTask).(*task).After("collect").SetLastRunTime(past)
+
+	s := NewScheduler(WithTickerInterval(time.Millisecond))
+	defer s.Stop()
+	s.Add(aggregate).Add(collect)
+	// the dependent task checks the dependencies on every tick,
+	// while the one-time tasks are reordered and removed
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		s.Add(NewTaskAt(now.Add(time.Duration(i)*time.Millisecond)).Do(fmt.Sprintf("once%d", i), testTask))
+	}
+	require.NoError(t, s.Start())
+
+	assert.Eventually(t, func() bool {
+		return s.Count() == 2
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint32(1), collect.RunCount())
+	assert.Equal(t, uint32(0), aggregate.RunCount())
+}
+
+func Test_SequentialExecution(t *testing.T) {
+	var running, maxRunning int32
+	var lock sync.Mutex
+	var calls []string
+	record := func(name string) func() {
+		return func() {
+			n := atomic.AddInt32(&running, 1)
+			if n > atomic.LoadInt32(&maxRunning) {
+				atomic.StoreInt32(&maxRunning, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			calls = append(calls, name)
+			lock.Unlock()
+			atomic.AddInt32(&running, -1)
+		}
+	}
+
+	now := time.Now()
+	t1 := NewTaskAtIntervals(1, Hours).Do("t1", record("t1")).(*task).SetLastRunTime(now.Add(-2 * time.Hour))
+	t2 := NewTaskAtIntervals(1, Hours).Do("t2", record("t2")).(*task).SetLastRunTime(now.Add(-3 * time.Hour))
+	t3 := NewTaskAtIntervals(1, Hours).Do("t3", record("t3")).(*task).After("t1").SetLastRunTime(now.Add(-4 * time.Hour))
+
+	s := NewScheduler(WithSequentialExecution()).(*scheduler)
+	s.Add(t1).Add(t2).Add(t3)
+	require.NoError(t, s.checkDependencies())
+
+	// the tasks have run on return
+	s.runPending()
+	assert.Equal(t, []string{"t2", "t1", "t3"}, calls)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&maxRunning))
+	assert.False(t, t1.ShouldRun())
+}
+
+func Test_SequentialStopFromTask(t *testing.T) {
+	stopped := make(chan struct{})
+	var s Scheduler
+	s = NewScheduler(
+		WithSequentialExecution(),
+		WithTickerInterval(10*time.Millisecond),
+		WithOnStop(func() {
+			close(stopped)
+		}),
+	)
+	s.Add(NewTaskAtIntervals(1, Seconds).Do("stop", func() {
+		assert.NoError(t, s.Stop())
+	}))
+	require.NoError(t, s.Start())
+
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("the scheduler loop did not exit")
+	}
+}
+
+func Test_DependenciesCheck(t *testing.T) {
+	s := NewScheduler()
+	s.Add(NewTaskAtIntervals(1, Hours).Do("a", testTask).(*task).After("b"))
+	s.Add(NewTaskAtIntervals(1, Hours).Do("b", testTask).(*task).After("c"))
+	s.Add(NewTaskAtIntervals(1, Hours).Do("c", testTask).(*task).After("a"))
+	err := s.Start()
+	assert.EqualError(t, err, "cyclic dependency: a@tasks.testTask -> b@tasks.testTask -> c@tasks.testTask -> a@tasks.testTask")
+	assert.False(t, s.IsRunning())
+
+	s.Clear()
+	s.Add(NewTaskAtIntervals(1, Hours).Do("a", testTask).(*task).After("missing"))
+	err = s.Start()
+	assert.EqualError(t, err, "dependency not found: missing, task: a@tasks.testTask")
+}
@@ -0,0 +1,99 @@
+package tasks
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StateStore provides persistence for the tasks state,
+// the state is matched by the task name
+type StateStore interface {
+	// LastRunTime returns the time of last run of the task,
+	// or zero time if the state is not found
+	LastRunTime(name string) (time.Time, error)
+	// SetLastRunTime persists the time of last run of the task
+	SetLastRunTime(name string, lastRun time.Time) error
+}
+
+// NewNopStateStore returns StateStore that does not persist the state
+func NewNopStateStore() StateStore {
+	return nopStateStore{}
+}
+
+type nopStateStore struct{}
+
+func (nopStateStore) LastRunTime(name string) (time.Time, error) {
+	return time.Time{}, nil
+}
+
+func (nopStateStore) SetLastRunTime(name string, lastRun time.Time) error {
+	return nil
+}
+
+// fileStateStore persists the tasks state in JSON file
+type fileStateStore struct {
+	path  string
+	lock  sync.Mutex
+	state map[string]time.Time
+}
+
+// NewFileStateStore returns StateStore that persists the state in JSON file,
+// the file is created on the first update if it does not exist
+func NewFileStateStore(path string) (StateStore, error) {
+	s := &fileStateStore{
+		path:  path,
+		state: map[string]time.Time{},
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.WithMessagef(err, "unable to read state")
+	}
+	if len(b) > 0 {
+		if err = json.Unmarshal(b, &s.state); err != nil {
+			return nil, errors.WithMessagef(err, "unable to decode state: %s", path)
+		}
+	}
+	return s, nil
+}
+
+// LastRunTime returns the time of last run of the task
+func (s *fileStateStore) LastRunTime(name string) (time.Time, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state[name], nil
+}
+
+// SetLastRunTime persists the time of last run of the task
+func (s *fileStateStore) SetLastRunTime(name string, lastRun time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.state[name] = lastRun
+
+	b, err := json.MarshalIndent(s.state, "", "\t")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// write to a temp file first, to not corrupt the state on failure
+	tmp := s.path + ".tmp"
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return errors.WithMessagef(err, "unable to create folder")
+	}
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return errors.WithMessagef(err, "unable to write state")
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return errors.WithMessagef(err, "unable to write state")
+	}
+	return nil
+}
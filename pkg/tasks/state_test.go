@@ -0,0 +1,75 @@
+package tasks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNopStateStore(t *testing.T) {
+	s := NewNopStateStore()
+	require.NoError(t, s.SetLastRunTime("test", time.Now()))
+	lastRun, err := s.LastRunTime("test")
+	require.NoError(t, err)
+	assert.True(t, lastRun.IsZero())
+}
+
+func TestFileStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "tasks.json")
+
+	s, err := NewFileStateStore(path)
+	require.NoError(t, err)
+
+	lastRun, err := s.LastRunTime("test")
+	require.NoError(t, err)
+	assert.True(t, lastRun.IsZero())
+
+	now := time.Now().Truncate(time.Second)
+	require.NoError(t, s.SetLastRunTime("test", now))
+
+	// reload
+	s, err = NewFileStateStore(path)
+	require.NoError(t, err)
+	lastRun, err = s.LastRunTime("test")
+	require.NoError(t, err)
+	assert.True(t, now.Equal(lastRun))
+
+	require.NoError(t, os.WriteFile(path, []byte("invalid"), 0644))
+	_, err = NewFileStateStore(path)
+	assert.Error(t, err)
+}
+
+func Test_RestoreState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.json")
+	store, err := NewFileStateStore(path)
+	require.NoError(t, err)
+
+	overdue := NewTaskAtIntervals(1, Hours).Do("overdue", testTask)
+	recent := NewTaskAtIntervals(1, Hours).Do("recent", testTask)
+
+	now := time.Now()
+	require.NoError(t, store.SetLastRunTime(overdue.Name(), now.Add(-2*time.Hour)))
+	require.NoError(t, store.SetLastRunTime(recent.Name(), now))
+
+	scheduler := NewScheduler(WithStateStore(store), WithTickerInterval(10*time.Millisecond))
+	scheduler.Add(overdue).Add(recent)
+	require.NoError(t, scheduler.Start())
+	defer scheduler.Stop()
+
+	assert.True(t, recent.NextScheduledTime().After(now))
+
+	// overdue task must run immediately after restore
+	assert.Eventually(t, func() bool {
+		return overdue.RunCount() == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint32(0), recent.RunCount())
+
+	assert.Eventually(t, func() bool {
+		lastRun, _ := store.LastRunTime(overdue.Name())
+		return !lastRun.Before(now)
+	}, time.Second, 10*time.Millisecond)
+}
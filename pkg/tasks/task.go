@@ -7,6 +7,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -30,6 +31,8 @@ const (
 	Days
 	// Weeks specifies the time unit in weeks
 	Weeks
+	// Once specifies the task to run only once at the specified time
+	Once
 )
 
 // Task defines task interface
@@ -42,6 +45,9 @@ type Task interface {
 	NextScheduledTime() time.Time
 	// LastRunTime returns the time of last run
 	LastRunTime() time.Time
+	// LastError returns the error of last run,
+	// or nil if the last run succeeded or the task has not run yet
+	LastError() error
 	// Duration returns interval between runs
 	Duration() time.Duration
 
@@ -56,6 +62,41 @@ type Task interface {
 	Do(taskName string, task interface{}, params ...interface{}) Task
 }
 
+// TaskStatus is an optional interface of Task,
+// that provides the outcome of the runs.
+// The scheduler uses it for the metrics and the dependencies
+type TaskStatus interface {
+	// FailureCount species the number of times the task returned an error
+	FailureCount() uint32
+	// IsRunning returns true if the task is running now
+	IsRunning() bool
+	// LastSuccessTime returns the time of last successful run
+	LastSuccessTime() time.Time
+}
+
+// StatefulTask is an optional interface of Task,
+// that is used by the scheduler to restore the state from StateStore
+type StatefulTask interface {
+	// SetLastRunTime restores the time of last run,
+	// and reschedules the next run
+	SetLastRunTime(lastRun time.Time) Task
+}
+
+// DependentTask is an optional interface of Task,
+// that is used by the scheduler to run the task after its dependencies
+type DependentTask interface {
+	// Dependencies returns the names of the tasks that this task depends on
+	Dependencies() []string
+}
+
+// MissedRunsSkipper is an optional interface of Task,
+// that is used by Scheduler.Resume to skip the runs missed during the pause
+type MissedRunsSkipper interface {
+	// SkipMissed moves the next run after now, without running the missed runs.
+	// The task may keep the missed runs to catch up on the next tick
+	SkipMissed(now time.Time)
+}
+
 // task describes a task schedule
 type task struct {
 	// pause interval * unit bettween runs
@@ -64,14 +105,28 @@ type task struct {
 	unit TimeUnit
 	// number of runs
 	count uint32
+	// number of failed runs
+	failures uint32
 	// datetime of last run
 	lastRunAt *time.Time
+	// datetime of last successful run
+	lastSuccessAt time.Time
+	// error of last run
+	lastErr atomic.Value
 	// datetime of next run
 	nextRunAt time.Time
 	// cache the period between last an next run
 	period time.Duration
 	// Specific day of the week to start on
 	startDay time.Weekday
+	// days of the week to run on, empty for any
+	weekdays []time.Weekday
+	// days of the month to run on, empty for any
+	monthDays []int
+	// names of the tasks to run after
+	dependencies []string
+	// max number of the missed runs to catch up
+	catchUp int
 
 	// the task name
 	name string
@@ -81,7 +136,13 @@ type task struct {
 	params []reflect.Value
 
 	runLock chan struct{}
-	running bool
+	// running is set while the task runs on the worker goroutine
+	running atomic.Bool
+	// done is set when the one-time task has run
+	done atomic.Bool
+	// lock protects the times of the runs,
+	// that are updated by Run on the worker goroutine
+	lock sync.RWMutex
 	// timeout interval to schedule a run
 	runTimeout time.Duration
 }
@@ -142,6 +203,23 @@ func NewTaskDaily(hour, minute int) Task {
 	return j.at(hour, minute)
 }
 
+// NewTaskAt creates a new task to execute once at specific time.
+// The task is removed from the scheduler after the run.
+// If the time is in the past, the task runs on the first tick after Start,
+// unless the scheduler is created with WithSkipPastDue option
+func NewTaskAt(at time.Time) Task {
+	return &task{
+		unit:       Once,
+		lastRunAt:  nil,
+		nextRunAt:  at,
+		period:     0,
+		startDay:   time.Sunday,
+		runLock:    make(chan struct{}, 1),
+		count:      0,
+		runTimeout: DefaultRunTimeoutInterval,
+	}
+}
+
 // NewTask creates a new task from parsed format string.
 // every %d
 // seconds | minutes | ...
@@ -161,24 +239,197 @@ func (j *task) RunCount() uint32 {
 	return atomic.LoadUint32(&j.count)
 }
 
+// FailureCount species the number of times the task returned an error
+func (j *task) FailureCount() uint32 {
+	return atomic.LoadUint32(&j.failures)
+}
+
+// IsRunning returns true if the task is running now
+func (j *task) IsRunning() bool {
+	return j.running.Load()
+}
+
 // ShouldRun returns true if the task should be run now
 func (j *task) ShouldRun() bool {
-	return !j.running && time.Now().After(j.nextRunAt)
+	if j.running.Load() || j.done.Load() {
+		return false
+	}
+	if j.catchUp > 0 {
+		// the monotonic clock does not advance while the system sleeps,
+		// so the wall clock is used to detect the missed runs
+		return time.Now().Round(0).After(j.NextScheduledTime().Round(0))
+	}
+	return time.Now().After(j.NextScheduledTime())
+}
+
+// missedRuns returns the number of runs missed by the wall clock,
+// up to catchUp, that are coalesced into the current run
+func (j *task) missedRuns(now time.Time) int {
+	period := j.Duration()
+	if j.catchUp == 0 || period == 0 {
+		return 0
+	}
+
+	j.lock.RLock()
+	next := j.nextRunAt.Round(0)
+	j.lock.RUnlock()
+	now = now.Round(0)
+	if !now.After(next) {
+		return 0
+	}
+	missed := int64(now.Sub(next)/period) + 1
+	if missed > int64(j.catchUp) {
+		missed = int64(j.catchUp)
+	}
+	return int(missed)
 }
 
 // NextScheduledTime returns the time of when this task is to run next
 func (j *task) NextScheduledTime() time.Time {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
 	return j.nextRunAt
 }
 
 // LastRunTime returns the time of last run
 func (j *task) LastRunTime() time.Time {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
 	if j.lastRunAt != nil {
 		return *j.lastRunAt
 	}
 	return time.Unix(0, 0)
 }
 
+// LastSuccessTime returns the time of last successful run
+func (j *task) LastSuccessTime() time.Time {
+	j.lock.RLock()
+	defer j.lock.RUnlock()
+	return j.lastSuccessAt
+}
+
+// LastError returns the error of last run,
+// or nil if the last run succeeded or the task has not run yet
+func (j *task) LastError() error {
+	if r, ok := j.lastErr.Load().(runResult); ok {
+		return r.err
+	}
+	return nil
+}
+
+// runResult wraps the error of the run to store in atomic.Value,
+// that does not accept nil
+type runResult struct {
+	err error
+}
+
+// After specifies the names of the tasks that this task depends on.
+// The task runs only after the dependencies have run successfully
+// since its own last run, and after the dependencies scheduled in the same tick.
+// The name is the one provided to Do
+func (j *task) After(names ...string) *task {
+	j.dependencies = append(j.dependencies, names...)
+	return j
+}
+
+// Dependencies returns the names of the tasks that this task depends on
+func (j *task) Dependencies() []string {
+	return j.dependencies
+}
+
+// CatchUp specifies to run the task once on the first tick,
+// if it missed runs by the wall clock, for example
+// when the system was asleep or the scheduler was paused.
+// Up to max missed runs are coalesced into the single run,
+// and the next run is scheduled after it.
+// By default, the task runs when the monotonic clock reaches the next run,
+// that is delayed by the time the system was asleep,
+// and the runs missed during the pause are skipped
+func (j *task) CatchUp(max int) *task {
+	j.catchUp = max
+	return j
+}
+
+// SetLastRunTime restores the time of last run,
+// and reschedules the next run
+func (j *task) SetLastRunTime(lastRun time.Time) Task {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.lastRunAt = &lastRun
+	if j.unit == Once && !lastRun.Before(j.nextRunAt) {
+		// the one-time task has already run
+		j.done.Store(true)
+	}
+	j.scheduleNextRunLocked()
+	return j
+}
+
+// Weekday restricts the task to run only on the specified days of the week,
+// the next run is moved to the first matching day at the same time
+func (j *task) Weekday(days ...time.Weekday) *task {
+	for _, d := range days {
+		if d < time.Sunday || d > time.Saturday {
+			logger.Panicf("invalid weekday value: %d", d)
+		}
+	}
+	j.weekdays = append(j.weekdays, days...)
+	j.reschedule()
+	return j
+}
+
+// Day restricts the task to run only on the specified days of the month,
+// the next run is moved to the first matching day at the same time.
+// If both Weekday and Day are specified, the day must match both.
+func (j *task) Day(days ...int) *task {
+	for _, d := range days {
+		if d < 1 || d > 31 {
+			logger.Panicf("invalid day value: %d", d)
+		}
+	}
+	j.monthDays = append(j.monthDays, days...)
+	j.reschedule()
+	return j
+}
+
+// reschedule computes the next run, if the task was already scheduled
+func (j *task) reschedule() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.lastRunAt != nil {
+		j.scheduleNextRunLocked()
+	}
+}
+
+// matchDay returns true if the day of t matches
+// the weekday and day of the month constraints
+func (j *task) matchDay(t time.Time) bool {
+	if len(j.weekdays) > 0 {
+		found := false
+		for _, d := range j.weekdays {
+			if t.Weekday() == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(j.monthDays) > 0 {
+		found := false
+		for _, d := range j.monthDays {
+			if t.Day() == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
 // // Duration returns interval between runs
 func (j *task) Duration() time.Duration {
 	if j.period == 0 {
@@ -198,7 +449,9 @@ func (j *task) Duration() time.Duration {
 	return j.period
 }
 
-// Do accepts a function that should be called every time the task runs
+// Do accepts a function that should be called every time the task runs.
+// If the last value returned by the function is a non-nil error,
+// then the run is counted as failed
 func (j *task) Do(taskName string, taskFunc interface{}, params ...interface{}) Task {
 	typ := reflect.TypeOf(taskFunc)
 	if typ.Kind() != reflect.Func {
@@ -250,6 +503,19 @@ func (j *task) at(hour, min int) *task {
 
 // scheduleNextRun computes the instant when this task should run next
 func (j *task) scheduleNextRun() time.Time {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return j.scheduleNextRunLocked()
+}
+
+// scheduleNextRunLocked computes the next run,
+// the caller must hold the lock
+func (j *task) scheduleNextRunLocked() time.Time {
+	if j.unit == Once {
+		// the one-time task is not rescheduled
+		return j.nextRunAt
+	}
+
 	now := time.Now()
 	if j.lastRunAt == nil {
 		if j.unit == Weeks {
@@ -264,6 +530,11 @@ func (j *task) scheduleNextRun() time.Time {
 	}
 
 	j.nextRunAt = j.lastRunAt.Add(j.Duration())
+	// skip the days not matching the constraints,
+	// a year is enough to find any valid day of month
+	for i := 0; i < 366 && !j.matchDay(j.nextRunAt); i++ {
+		j.nextRunAt = j.nextRunAt.AddDate(0, 0, 1)
+	}
 	/*
 		logger.KV(xlog.DEBUG,
 			"lastRunAt",j.lastRunAt.Format(time.RFC3339),
@@ -273,6 +544,37 @@ func (j *task) scheduleNextRun() time.Time {
 	return j.nextRunAt
 }
 
+// SkipMissed moves the next run after now, without running the missed runs,
+// unless the task is running or configured with CatchUp
+func (j *task) SkipMissed(now time.Time) {
+	if j.running.Load() || j.catchUp > 0 {
+		return
+	}
+	j.skipMissed(now)
+}
+
+// skipMissed moves the next run after now,
+// without running the missed runs
+func (j *task) skipMissed(now time.Time) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.skipMissedLocked(now)
+}
+
+// skipMissedLocked moves the next run after now,
+// the caller must hold the lock
+func (j *task) skipMissedLocked(now time.Time) {
+	period := j.Duration()
+	if j.unit == Once || period == 0 || !j.nextRunAt.Before(now) {
+		return
+	}
+	missed := now.Sub(j.nextRunAt)/period + 1
+	j.nextRunAt = j.nextRunAt.Add(missed * period)
+	for i := 0; i < 366 && !j.matchDay(j.nextRunAt); i++ {
+		j.nextRunAt = j.nextRunAt.AddDate(0, 0, 1)
+	}
+}
+
 // for given function fn, get the name of function.
 func getFunctionName(fn interface{}) string {
 	return runtime.FuncForPC(reflect.ValueOf((fn)).Pointer()).Name()
@@ -291,18 +593,41 @@ func (j *task) Run() bool {
 	case j.runLock <- struct{}{}:
 		timer.Stop()
 		now := time.Now()
+		if missed := j.missedRuns(now); missed > 0 {
+			logger.KV(xlog.DEBUG,
+				"status", "catch_up",
+				"missed", missed,
+				"task", j.Name())
+		}
+		j.lock.Lock()
 		j.lastRunAt = &now
-		j.running = true
+		j.lock.Unlock()
+		j.running.Store(true)
 		count := atomic.AddUint32(&j.count, 1)
 
 		logger.KV(xlog.DEBUG,
 			"status", "running",
 			"count", count,
-			"started_at", j.lastRunAt,
+			"started_at", now,
 			"task", j.Name())
 
-		j.callback.Call(j.params)
-		j.running = false
+		res := j.callback.Call(j.params)
+		err := lastError(res)
+		j.lastErr.Store(runResult{err: err})
+		if err != nil {
+			failures := atomic.AddUint32(&j.failures, 1)
+			logger.KV(xlog.ERROR,
+				"status", "failed",
+				"failures", failures,
+				"task", j.Name(),
+				"err", err.Error())
+		} else {
+			j.lock.Lock()
+			j.lastSuccessAt = now
+			j.lock.Unlock()
+		}
+		j.running.Store(false)
+		j.done.Store(j.unit == Once)
 		j.scheduleNextRun()
 		<-j.runLock
 		return true
@@ -311,13 +636,41 @@ func (j *task) Run() bool {
 
 	logger.KV(xlog.DEBUG,
 		"status", "already_running",
-		"count", j.count,
-		"started_at", j.lastRunAt,
+		"count", j.RunCount(),
+		"started_at", j.LastRunTime(),
 		"task", j.Name())
 
 	return false
 }
 
+// isOneTime returns true if the task runs only once
+func isOneTime(t Task) bool {
+	j, ok := t.(*task)
+	return ok && j.unit == Once
+}
+
+// isDone returns true if the one-time task has run
+func isDone(t Task) bool {
+	j, ok := t.(*task)
+	return ok && j.done.Load()
+}
+
+// matchName returns true if the task has the name,
+// provided to Do
+func matchName(t Task, name string) bool {
+	n := t.Name()
+	return n == name || strings.HasPrefix(n, name+"@")
+}
+
+// lastError returns the error, if it's the last returned value
+func lastError(res []reflect.Value) error {
+	if len(res) == 0 {
+		return nil
+	}
+	err, _ := res[len(res)-1].Interface().(error)
+	return err
+}
+
 func parseTimeFormat(t string) (hour, min int, err error) {
 	var errTimeFormat = errors.Errorf("time format not valid: %q", t)
 	ts := strings.Split(t, ":")
@@ -280,6 +280,154 @@ func Test_TaskWeekdaysTodayBefore(t *testing.T) {
 	assert.Equal(t, timeToSchedule, job1.NextScheduledTime(), "Task should be run today, at the set time.")
 }
 
+func Test_TaskDayConstraints(t *testing.T) {
+	job1 := NewTaskDaily(10, 30).(*task).Weekday(time.Monday).Do("test", testTask)
+	next := job1.NextScheduledTime()
+	assert.Equal(t, time.Monday, next.Weekday())
+	assert.Equal(t, 10, next.Hour())
+	assert.Equal(t, 30, next.Minute())
+	assert.True(t, next.After(time.Now()))
+
+	job2 := NewTaskDaily(8, 0).(*task).Day(15).Do("test", testTask)
+	next = job2.NextScheduledTime()
+	assert.Equal(t, 15, next.Day())
+	assert.Equal(t, 8, next.Hour())
+	assert.True(t, next.After(time.Now()))
+
+	// first Monday of the month
+	job3 := NewTaskDaily(9, 0).(*task).Day(1, 2, 3, 4, 5, 6, 7).Weekday(time.Monday).Do("test", testTask)
+	next = job3.NextScheduledTime()
+	assert.Equal(t, time.Monday, next.Weekday())
+	assert.LessOrEqual(t, next.Day(), 7)
+
+	// reschedule after run
+	job3.(*task).SetLastRunTime(next)
+	next2 := job3.NextScheduledTime()
+	assert.Equal(t, time.Monday, next2.Weekday())
+	assert.LessOrEqual(t, next2.Day(), 7)
+	assert.NotEqual(t, next.Month(), next2.Month())
+
+	require.Panics(t, func() {
+		NewTaskDaily(0, 0).(*task).Day(32)
+	})
+	require.Panics(t, func() {
+		NewTaskDaily(0, 0).(*task).Weekday(time.Weekday(7))
+	})
+}
+
+func Test_TaskAt(t *testing.T) {
+	at := time.Now().Add(time.Hour)
+	job1 := NewTaskAt(at).Do("test", testTask).(*task)
+	assert.Equal(t, at, job1.NextScheduledTime())
+	assert.Equal(t, time.Duration(0), job1.Duration())
+	assert.False(t, job1.ShouldRun())
+	assert.True(t, isOneTime(job1))
+
+	job2 := NewTaskAt(time.Now().Add(-time.Second)).Do("test", testTask)
+	assert.True(t, job2.ShouldRun())
+	require.True(t, job2.Run())
+	assert.True(t, isDone(job2))
+	assert.False(t, job2.ShouldRun())
+	assert.Equal(t, uint32(1), job2.RunCount())
+
+	// restored run before the scheduled time is from an earlier schedule
+	job1.SetLastRunTime(at.Add(-time.Minute))
+	assert.False(t, isDone(job1))
+	assert.Equal(t, at, job1.NextScheduledTime())
+	job1.SetLastRunTime(at)
+	assert.True(t, isDone(job1))
+
+	assert.False(t, isOneTime(NewTaskAtIntervals(1, Hours)))
+}
+
+func Test_skipMissed(t *testing.T) {
+	now := time.Now()
+	job := NewTaskAtIntervals(1, Minutes).Do("test", testTask).(*task)
+	job.SetLastRunTime(now.Add(-150 * time.Second))
+	assert.True(t, job.ShouldRun())
+
+	job.skipMissed(now)
+	assert.False(t, job.ShouldRun())
+	assert.Equal(t, now.Add(30*time.Second).Unix(), job.NextScheduledTime().Unix())
+
+	// not missed
+	next := job.NextScheduledTime()
+	job.skipMissed(now)
+	assert.Equal(t, next, job.NextScheduledTime())
+
+	// the task with CatchUp keeps the missed runs
+	catchUp := NewTaskAtIntervals(1, Minutes).Do("test", testTask).(*task).CatchUp(3)
+	catchUp.SetLastRunTime(now.Add(-150 * time.Second))
+	next = catchUp.NextScheduledTime()
+	catchUp.SkipMissed(now)
+	assert.Equal(t, next, catchUp.NextScheduledTime())
+	job.SkipMissed(now.Add(time.Minute))
+	assert.True(t, job.NextScheduledTime().After(now.Add(time.Minute)))
+
+	// one-time task is not skipped
+	once := NewTaskAt(now.Add(-time.Minute)).(*task)
+	once.skipMissed(now)
+	assert.True(t, once.ShouldRun())
+}
+
+func Test_CatchUp(t *testing.T) {
+	now := time.Now()
+	job := NewTaskAtIntervals(1, Hours).Do("test", testTask).(*task).CatchUp(3)
+
+	// not due
+	job.SetLastRunTime(now.Add(-30 * time.Minute))
+	assert.False(t, job.ShouldRun())
+
+	// 3 missed runs are caught up by a single run
+	job.SetLastRunTime(now.Add(-210 * time.Minute))
+	assert.True(t, job.ShouldRun())
+	require.True(t, job.Run())
+	assert.False(t, job.ShouldRun())
+	assert.Equal(t, uint32(1), job.RunCount())
+
+	// more missed runs than max are coalesced into a single run as well
+	job.SetLastRunTime(now.Add(-10*time.Hour - 30*time.Minute))
+	assert.Equal(t, 3, job.missedRuns(time.Now()))
+	assert.True(t, job.ShouldRun())
+	require.True(t, job.Run())
+	assert.Equal(t, uint32(2), job.RunCount())
+	assert.False(t, job.ShouldRun())
+	assert.True(t, job.NextScheduledTime().After(time.Now()))
+	assert.Equal(t, 0, job.missedRuns(time.Now()))
+
+	// the default runs regardless of the missed runs
+	job = NewTaskAtIntervals(1, Hours).Do("test", testTask).(*task)
+	job.SetLastRunTime(now.Add(-270 * time.Minute))
+	assert.True(t, job.ShouldRun())
+
+	built, err := NewTaskBuilder("test", testTask).Every(1).CatchUp(2).Hours()
+	require.NoError(t, err)
+	assert.Equal(t, 2, built.(*task).catchUp)
+}
+
+func Test_LastError(t *testing.T) {
+	var fail bool
+	job := NewTaskAtIntervals(1, Minutes).Do("test", func() error {
+		if fail {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+	assert.NoError(t, job.LastError())
+
+	fail = true
+	require.True(t, job.Run())
+	assert.EqualError(t, job.LastError(), "failed")
+	st := job.(TaskStatus)
+	assert.Equal(t, uint32(1), st.FailureCount())
+	assert.False(t, job.LastRunTime().Before(st.LastSuccessTime()))
+
+	fail = false
+	require.True(t, job.Run())
+	assert.NoError(t, job.LastError())
+	assert.Equal(t, job.LastRunTime(), st.LastSuccessTime())
+}
+
 func Test_NewTask_panic(t *testing.T) {
 	require.Panics(t, func() {
 		NewTaskOnWeekday(time.Wednesday, -1, 60)
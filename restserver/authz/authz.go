@@ -368,10 +368,8 @@ func (c *Provider) walkPath(path string, create bool) *pathNode {
 	return currentNode
 }
 
-// isAllowed returns true if access to 'path' is allowed for the specified role.
+// isAllowed returns true if access to 'path' is allowed for any of the identity's roles.
 func (c *Provider) isAllowed(ctx context.Context, path string, idn identity.Identity) bool {
-	role := idn.Role()
-
 	if len(path) == 0 || path[0] != '/' {
 		logger.ContextKV(ctx, xlog.NOTICE,
 			"status", "denied",
@@ -384,7 +382,13 @@ func (c *Provider) isAllowed(ctx context.Context, path string, idn identity.Iden
 	allowRole := false
 
 	if !allowAny {
-		allowRole = node.allowRole(role)
+		// any of identity's roles is allowed
+		for _, r := range identity.Roles(idn) {
+			if node.allowRole(r) {
+				allowRole = true
+				break
+			}
+		}
 	}
 	res := allowAny || allowRole
 	if res {
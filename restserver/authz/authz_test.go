@@ -178,6 +178,13 @@ func TestConfig_Allow(t *testing.T) {
 	check("/baz/baz", "bob", true)
 	check("/baz/baz", "baz", true)
 	check("/baz/baz", "alice", false)
+
+	// any of the roles is allowed
+	multi := identity.NewIdentityWithRoles([]string{"alice", "baz"}, "test", "", nil, "", "", nil)
+	checkAllowed(t, c, "/baz/baz", multi, true)
+	checkAllowed(t, c, "/foo/bar", multi, true)
+	checkAllowed(t, c, "/foo", multi, false)
+
 	c, err = New(&Config{
 		Allow: []string{
 			"/:bob",
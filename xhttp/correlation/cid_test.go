@@ -7,8 +7,10 @@ import (
 	"testing"
 
 	"github.com/effective-security/porto/xhttp/header"
+	"github.com/effective-security/xlog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
 
@@ -69,6 +71,45 @@ func Test_grpcFromContext(t *testing.T) {
 	})
 }
 
+type mockServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *mockServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func Test_grpcStreamFromContext(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		stream := NewStreamServerInterceptor()
+		var cid1 string
+		err := stream(nil, &mockServerStream{ctx: context.Background()}, nil, func(srv interface{}, ss grpc.ServerStream) error {
+			cid1 = ID(ss.Context())
+			assert.NotEmpty(t, cid1)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Len(t, cid1, IDSize)
+
+		octx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.XCorrelationID, "1234567890"))
+		err = stream(nil, &mockServerStream{ctx: octx}, nil, func(srv interface{}, ss grpc.ServerStream) error {
+			cid1 = ID(ss.Context())
+			assert.Contains(t, cid1, "1234567890")
+			return nil
+		})
+		require.NoError(t, err)
+
+		ctx := WithID(context.Background())
+		err = stream(nil, &mockServerStream{ctx: ctx}, nil, func(srv interface{}, ss grpc.ServerStream) error {
+			cid1 = ID(ss.Context())
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, ID(ctx), cid1)
+	})
+}
+
 func TestCorrelationIDHandler(t *testing.T) {
 	d := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cid := ID(r.Context())
@@ -107,3 +148,453 @@ func TestCorrelationIDHandler(t *testing.T) {
 		assert.Equal(t, "1234jsehdrlc", cid)
 	})
 }
+
+func TestCorrelationIDHandlerOptions(t *testing.T) {
+	d := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := NewHandler(d, WithHeaderName("X-Request-ID"), WithIDSize(16))
+
+	t.Run("no_from_client", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+
+		handler.ServeHTTP(rw, r)
+		assert.Len(t, rw.Header().Get("X-Request-ID"), 16)
+		assert.Empty(t, rw.Header().Get(header.XCorrelationID))
+	})
+
+	t.Run("show_from_client", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set("X-Request-ID", "1234")
+
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, "1234", rw.Header().Get("X-Request-ID"))
+	})
+
+	t.Run("long_from_client", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set("X-Request-ID", "1234jsehdrlcfkjwhelckjqhewlkcjhqwlekcjhqeq")
+
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, "1234jsehdrlcfkjw", rw.Header().Get("X-Request-ID"))
+	})
+}
+
+func TestIDGenerator(t *testing.T) {
+	gen := func() string {
+		return "host1-0188"
+	}
+
+	t.Run("http", func(t *testing.T) {
+		handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "host1-0188", ID(r.Context()))
+		}), WithIDGenerator(gen))
+
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, "host1-0188", rw.Header().Get(header.XCorrelationID))
+
+		// incoming ID is used
+		rw = httptest.NewRecorder()
+		r.Header.Set(header.XCorrelationID, "1234")
+		handler = NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), WithIDGenerator(gen))
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, "1234", rw.Header().Get(header.XCorrelationID))
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		unary := NewAuthUnaryInterceptor(WithIDGenerator(gen))
+		var cid string
+		_, _ = unary(context.Background(), nil, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			cid = ID(ctx)
+			return nil, nil
+		})
+		assert.Equal(t, "host1-0188", cid)
+
+		octx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.XCorrelationID, "1234567890"))
+		_, _ = unary(octx, nil, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			cid = ID(ctx)
+			return nil, nil
+		})
+		assert.Equal(t, "1234567890", cid)
+
+		// existing ID is preserved
+		ctx := WithID(context.Background())
+		_, _ = unary(ctx, nil, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			cid = ID(ctx)
+			return nil, nil
+		})
+		assert.Equal(t, ID(ctx), cid)
+	})
+}
+
+func TestRoundTripper(t *testing.T) {
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(header.XCorrelationID)
+	}))
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: NewRoundTripper(nil),
+	}
+
+	ctx := WithID(context.Background())
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(r)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, ID(ctx), received)
+	// the original request is not modified
+	assert.Empty(t, r.Header.Get(header.XCorrelationID))
+
+	// explicit header is preserved
+	r.Header.Set(header.XCorrelationID, "explicit")
+	resp, err = client.Do(r)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "explicit", received)
+
+	// no ID in the context
+	r, err = http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	resp, err = client.Do(r)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, received)
+}
+
+func TestTraceParent(t *testing.T) {
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	var cid string
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cid = ID(r.Context())
+	}), WithTraceParent())
+
+	t.Run("from_traceparent", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set(header.TraceParent, tp)
+
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, "4bf92f3577b3", cid)
+		assert.Equal(t, "4bf92f3577b3", rw.Header().Get(header.XCorrelationID))
+		assert.Equal(t, tp, rw.Header().Get(header.TraceParent))
+	})
+
+	t.Run("prefer_correlation", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set(header.TraceParent, tp)
+		r.Header.Set(header.XCorrelationID, "1234")
+
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, "1234", cid)
+		assert.Equal(t, tp, rw.Header().Get(header.TraceParent))
+	})
+
+	t.Run("new_traceparent", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set(header.TraceParent, "invalid")
+
+		handler.ServeHTTP(rw, r)
+		assert.Len(t, cid, IDSize)
+		_, ok := parseTraceParent(rw.Header().Get(header.TraceParent))
+		assert.True(t, ok)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set(header.TraceParent, tp)
+
+		NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rw, r)
+		assert.NotEqual(t, "4bf92f3577b3", rw.Header().Get(header.XCorrelationID))
+		assert.Empty(t, rw.Header().Get(header.TraceParent))
+	})
+}
+
+func Test_parseTraceParent(t *testing.T) {
+	tcases := []struct {
+		tp string
+		id string
+		ok bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "4bf92f3577b34da6a3ce929d0e0e4736", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "", false},
+		{"00-4bf92f3577b34da6-00f067aa0ba902b7-01", "", false},
+		{"", "", false},
+	}
+	for _, tc := range tcases {
+		id, ok := parseTraceParent(tc.tp)
+		assert.Equal(t, tc.ok, ok, tc.tp)
+		assert.Equal(t, tc.id, id, tc.tp)
+	}
+
+	_, ok := parseTraceParent(newTraceParent(""))
+	assert.True(t, ok)
+
+	id, ok := parseTraceParent(newTraceParent("4bf92f3577b34da6a3ce929d0e0e4736"))
+	assert.True(t, ok)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", id)
+}
+
+func TestTraceID(t *testing.T) {
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	var cid string
+	handler := NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cid = ID(r.Context())
+	}), WithTraceID(), WithTraceParent(), WithIDSize(8))
+
+	t.Run("generated", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+
+		handler.ServeHTTP(rw, r)
+		assert.True(t, isTraceID(cid), cid)
+		id, ok := parseTraceParent(rw.Header().Get(header.TraceParent))
+		assert.True(t, ok)
+		assert.Equal(t, cid, id)
+	})
+
+	t.Run("from_traceparent", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set(header.TraceParent, "00-"+traceID+"-00f067aa0ba902b7-01")
+
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, traceID, cid)
+		assert.Equal(t, traceID, rw.Header().Get(header.XCorrelationID))
+	})
+
+	t.Run("incoming", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set(header.XCorrelationID, "4BF92F3577B34DA6A3CE929D0E0E4736")
+
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, traceID, cid)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		rw := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set(header.XCorrelationID, "1234_"+traceID)
+
+		handler.ServeHTTP(rw, r)
+		assert.Equal(t, ("1234_" + traceID)[:TraceIDSize], cid)
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		unary := NewAuthUnaryInterceptor(WithTraceID())
+		octx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(header.XCorrelationID, traceID))
+		_, _ = unary(octx, nil, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+			cid = ID(ctx)
+			return nil, nil
+		})
+		assert.Equal(t, traceID, cid)
+	})
+}
+
+func TestLogHelpers(t *testing.T) {
+	assert.Nil(t, LogKV(context.Background()))
+
+	l := xlog.NewPackageLogger("github.com/effective-security/porto/xhttp", "correlation_test")
+	assert.Equal(t, l, Logger(context.Background(), l))
+
+	ctx := WithID(context.Background())
+	assert.Equal(t, []interface{}{LogKey, ID(ctx)}, LogKV(ctx))
+	assert.NotNil(t, Logger(ctx, l))
+
+	d := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entries := xlog.ContextEntries(r.Context())
+		assert.Equal(t, []interface{}{LogKey, ID(r.Context())}, entries)
+	})
+	r, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	NewHandler(d).ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestWithTestID(t *testing.T) {
+	ctx := WithTestID(context.Background(), "test-id")
+	assert.Equal(t, "test-id", ID(ctx))
+	assert.Equal(t, []interface{}{LogKey, "test-id"}, LogKV(ctx))
+
+	// WithID keeps the seeded ID
+	assert.Equal(t, "test-id", ID(WithID(ctx)))
+
+	md, ok := metadata.FromOutgoingContext(WithMetaFromContext(ctx))
+	require.True(t, ok)
+	assert.Equal(t, []string{"test-id"}, md[CorrelationIDgRPCHeaderName])
+
+	// replaces the original
+	ctx = WithTestID(WithID(context.Background()), "test-id2")
+	assert.Equal(t, "test-id2", ID(ctx))
+}
+
+func TestClientIP(t *testing.T) {
+	assert.Empty(t, ClientIP(context.Background()))
+
+	var ip string
+	d := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip = ClientIP(r.Context())
+	})
+
+	newRequest := func() *http.Request {
+		r, err := http.NewRequest("GET", "/test", nil)
+		require.NoError(t, err)
+		r.RemoteAddr = "10.0.0.1:12345"
+		r.Header.Set(header.XForwardedFor, "203.0.113.1, 10.0.0.2")
+		r.Header.Set(header.XRealIP, "203.0.113.2")
+		return r
+	}
+
+	// proxy headers are not trusted by default
+	NewHandler(d).ServeHTTP(httptest.NewRecorder(), newRequest())
+	assert.Equal(t, "10.0.0.1", ip)
+
+	// the last address is appended by the proxy
+	NewHandler(d, WithTrustedProxy()).ServeHTTP(httptest.NewRecorder(), newRequest())
+	assert.Equal(t, "10.0.0.2", ip)
+
+	// the trusted proxies are skipped
+	trusted := NewHandler(d, WithTrustedProxy("10.0.0.0/8", "192.0.2.1", "invalid"))
+	trusted.ServeHTTP(httptest.NewRecorder(), newRequest())
+	assert.Equal(t, "203.0.113.1", ip)
+
+	// the addresses before the first untrusted hop may be forged
+	r := newRequest()
+	r.Header.Set(header.XForwardedFor, "198.51.100.1, 203.0.113.1")
+	r.Header.Add(header.XForwardedFor, "10.0.0.2")
+	trusted.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "203.0.113.1", ip)
+
+	// all hops are trusted
+	r = newRequest()
+	r.Header.Set(header.XForwardedFor, "192.0.2.1, 10.0.0.2")
+	trusted.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "192.0.2.1", ip)
+
+	// the remote address is not a trusted proxy
+	r = newRequest()
+	r.RemoteAddr = "198.51.100.2:12345"
+	trusted.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "198.51.100.2", ip)
+
+	// not valid IP
+	r = newRequest()
+	r.Header.Set(header.XForwardedFor, "203.0.113.1, not-ip")
+	trusted.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "203.0.113.2", ip)
+	r.Header.Set(header.XRealIP, "not-ip")
+	trusted.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "10.0.0.1", ip)
+
+	r = newRequest()
+	r.Header.Del(header.XForwardedFor)
+	NewHandler(d, WithTrustedProxy()).ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "203.0.113.2", ip)
+
+	r = newRequest()
+	r.RemoteAddr = "10.0.0.1"
+	ctx := WithMetaFromRequest(r)
+	assert.Equal(t, "10.0.0.1", ClientIP(ctx))
+}
+
+func TestMaxSegments(t *testing.T) {
+	tcases := []struct {
+		id  string
+		max int
+		exp string
+	}{
+		{"root_a_b_c", 0, "root_a_b_c"},
+		{"root_a_b_c", 4, "root_a_b_c"},
+		{"root_a_b_c", 3, "root_b_c"},
+		{"root_a_b_c", 2, "root_c"},
+		{"root_a_b_c", 1, "root"},
+		{"root", 2, "root"},
+	}
+	for _, tc := range tcases {
+		assert.Equal(t, tc.exp, boundSegments(tc.id, tc.max), "%s:%d", tc.id, tc.max)
+	}
+
+	unary := NewAuthUnaryInterceptor(WithIDSize(64), WithMaxSegments(3))
+	var cid string
+	octx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(CorrelationIDgRPCHeaderName, "1234567890_12345678_abcdefgh_ABCDEFGH"))
+	_, err := unary(octx, nil, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+		cid = ID(ctx)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1234567890_abcdefgh_ABCDEFGH", cid)
+
+	d := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cid = ID(r.Context())
+	})
+	r, err := http.NewRequest("GET", "/test", nil)
+	require.NoError(t, err)
+	r.Header.Set(header.XCorrelationID, "root_a_b_c")
+	NewHandler(d, WithIDSize(64), WithMaxSegments(2)).ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "root_c", cid)
+}
+
+func TestSanitizeID(t *testing.T) {
+	assert.Equal(t, "abc-123_XYZ", sanitizeID("abc-123_XYZ"))
+	assert.Equal(t, "abclevelEforged", sanitizeID("abc\nlevel=E forged"))
+	assert.Empty(t, sanitizeID("\r\n\t"))
+
+	var cid string
+	d := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cid = ID(r.Context())
+	})
+	newRequest := func(id string) *http.Request {
+		r, _ := http.NewRequest("GET", "/test", nil)
+		r.Header.Set(header.XCorrelationID, id)
+		return r
+	}
+
+	rw := httptest.NewRecorder()
+	NewHandler(d).ServeHTTP(rw, newRequest("12\n34\"x"))
+	assert.Equal(t, "1234x", cid)
+	assert.Equal(t, "1234x", rw.Header().Get(header.XCorrelationID))
+
+	// only disallowed characters
+	NewHandler(d).ServeHTTP(httptest.NewRecorder(), newRequest("\n\n"))
+	assert.Len(t, cid, IDSize)
+
+	NewHandler(d, WithRegenerateInvalidID()).ServeHTTP(httptest.NewRecorder(), newRequest("12\n34"))
+	assert.Len(t, cid, IDSize)
+	assert.NotContains(t, cid, "\n")
+
+	NewHandler(d, WithRegenerateInvalidID()).ServeHTTP(httptest.NewRecorder(), newRequest("1234"))
+	assert.Equal(t, "1234", cid)
+
+	unary := NewAuthUnaryInterceptor(WithRegenerateInvalidID())
+	octx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(CorrelationIDgRPCHeaderName, "12\n34"))
+	_, err := unary(octx, nil, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+		cid = ID(ctx)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Len(t, cid, IDSize)
+	assert.NotContains(t, cid, "\n")
+
+	unary = NewAuthUnaryInterceptor()
+	_, err = unary(octx, nil, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+		cid = ID(ctx)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1234", cid)
+}
@@ -0,0 +1,35 @@
+package correlation
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// NewUnaryClientInterceptor returns grpc.UnaryClientInterceptor that
+// sets Correlation ID in the outgoing metadata.
+// If the context has no Correlation ID, then a new one is generated
+func NewUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(withOutgoingID(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// NewStreamClientInterceptor returns grpc.StreamClientInterceptor that
+// sets Correlation ID in the outgoing metadata.
+// If the context has no Correlation ID, then a new one is generated
+func NewStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withOutgoingID(ctx), desc, cc, method, opts...)
+	}
+}
+
+// withOutgoingID returns context with Correlation ID in the outgoing metadata,
+// the existing outgoing Correlation ID is preserved
+func withOutgoingID(ctx context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(CorrelationIDgRPCHeaderName)) > 0 {
+		return ctx
+	}
+	return WithMetaFromContext(ctx)
+}
@@ -0,0 +1,48 @@
+package correlation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestClientInterceptors(t *testing.T) {
+	var cid string
+	var md metadata.MD
+
+	unary := NewUnaryClientInterceptor()
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		cid = ID(ctx)
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+
+	ctx := WithID(context.Background())
+	require.NoError(t, unary(ctx, "/test", nil, nil, nil, invoker))
+	assert.Equal(t, ID(ctx), cid)
+	assert.Equal(t, []string{cid}, md.Get(CorrelationIDgRPCHeaderName))
+
+	// generated
+	require.NoError(t, unary(context.Background(), "/test", nil, nil, nil, invoker))
+	assert.Len(t, cid, IDSize)
+	assert.Equal(t, []string{cid}, md.Get(CorrelationIDgRPCHeaderName))
+
+	// existing outgoing ID is preserved
+	octx := metadata.AppendToOutgoingContext(ctx, CorrelationIDgRPCHeaderName, "outgoing")
+	require.NoError(t, unary(octx, "/test", nil, nil, nil, invoker))
+	assert.Equal(t, []string{"outgoing"}, md.Get(CorrelationIDgRPCHeaderName))
+
+	stream := NewStreamClientInterceptor()
+	_, err := stream(ctx, nil, nil, "/test", func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cid = ID(ctx)
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ID(ctx), cid)
+	assert.Equal(t, []string{cid}, md.Get(CorrelationIDgRPCHeaderName))
+}
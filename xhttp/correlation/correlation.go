@@ -2,6 +2,7 @@ package correlation
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"strings"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/effective-security/porto/xhttp/header"
 	"github.com/effective-security/xlog"
 	"github.com/effective-security/xpki/certutil"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
@@ -28,6 +30,10 @@ const (
 // IDSize specifies a size in characters for the correlation ID
 const IDSize = 12
 
+// TraceIDSize specifies a size in characters for the Correlation ID,
+// compatible with OpenTelemetry trace-id
+const TraceIDSize = 32
+
 // Correlator interface allows to provide request ID
 type Correlator interface {
 	CorrelationID() string
@@ -37,18 +43,22 @@ type Correlator interface {
 // it includes ID, aka Request-ID or Correlation-ID (for cross system request correlation).
 type RequestContext struct {
 	ID string
+	// ClientIP is the client address captured from HTTP request
+	ClientIP string
 }
 
 // NewHandler returns a handler that will extact/add the correlationID from the request
 // and stash them away in the request context for later handlers to use.
-func NewHandler(delegate http.Handler) http.Handler {
+func NewHandler(delegate http.Handler, ops ...Option) http.Handler {
+	o := newOptions(ops)
 	h := func(w http.ResponseWriter, r *http.Request) {
 		var rctx *RequestContext
 		ctx := r.Context()
 		v := ctx.Value(keyContext)
 		if v == nil {
 			rctx = &RequestContext{
-				ID: correlationID(r),
+				ID:       correlationID(r, o),
+				ClientIP: clientIP(r, o),
 			}
 			r = r.WithContext(context.WithValue(ctx, keyContext, rctx))
 		} else {
@@ -56,9 +66,16 @@ func NewHandler(delegate http.Handler) http.Handler {
 		}
 
 		// add correlationID to logs as "ctx"
-		r = r.WithContext(xlog.ContextWithKV(r.Context(), "ctx", rctx.ID))
+		r = r.WithContext(xlog.ContextWithKV(r.Context(), LogKey, rctx.ID))
 
-		w.Header().Set(header.XCorrelationID, rctx.ID)
+		w.Header().Set(o.header, rctx.ID)
+		if o.traceParent {
+			tp := r.Header.Get(header.TraceParent)
+			if _, ok := parseTraceParent(tp); !ok {
+				tp = newTraceParent(rctx.ID)
+			}
+			w.Header().Set(header.TraceParent, tp)
+		}
 		delegate.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(h)
@@ -66,26 +83,49 @@ func NewHandler(delegate http.Handler) http.Handler {
 
 // NewAuthUnaryInterceptor returns grpc.UnaryServerInterceptor that
 // identity to the context
-func NewAuthUnaryInterceptor() grpc.UnaryServerInterceptor {
+func NewAuthUnaryInterceptor(ops ...Option) grpc.UnaryServerInterceptor {
+	o := newOptions(ops)
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		var rctx *RequestContext
-		v := ctx.Value(keyContext)
-		if v == nil {
+		rctx := Value(ctx)
+		if rctx == nil {
 			rctx = &RequestContext{
-				ID: correlationIDFromGRPC(ctx),
+				ID: correlationIDFromGRPC(ctx, o),
 			}
 			ctx = context.WithValue(ctx, keyContext, rctx)
 		}
 
 		// add correlationID to logs as "ctx"
-		ctx = xlog.ContextWithKV(ctx, "ctx", rctx.ID)
+		ctx = xlog.ContextWithKV(ctx, LogKey, rctx.ID)
 
 		return handler(ctx, req)
 	}
 }
 
+// NewStreamServerInterceptor returns grpc.StreamServerInterceptor that
+// adds Correlation ID to the stream context
+func NewStreamServerInterceptor(ops ...Option) grpc.StreamServerInterceptor {
+	o := newOptions(ops)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		rctx := Value(ctx)
+		if rctx == nil {
+			rctx = &RequestContext{
+				ID: correlationIDFromGRPC(ctx, o),
+			}
+			ctx = context.WithValue(ctx, keyContext, rctx)
+		}
+
+		// add correlationID to logs as "ctx"
+		ctx = xlog.ContextWithKV(ctx, LogKey, rctx.ID)
+
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
 // correlationIDFromGRPC will find or create a requestID for this request.
-func correlationIDFromGRPC(ctx context.Context) string {
+func correlationIDFromGRPC(ctx context.Context, o *options) string {
 	corID := ID(ctx)
 	if corID == "" {
 		incomingID := ""
@@ -103,31 +143,39 @@ func correlationIDFromGRPC(ctx context.Context) string {
 			}
 		}
 		if incomingID != "" {
-			corID = slices.StringUpto(incomingID, IDSize)
-		} else {
-			corID = certutil.RandomString(IDSize)
+			corID = fromIncoming(incomingID, o)
+		}
+		if corID == "" {
+			corID = o.gen()
 		}
-		logger.ContextKV(ctx, xlog.DEBUG, "ctx", corID, "incoming_ctx", incomingID)
+		logger.ContextKV(ctx, xlog.DEBUG, LogKey, corID, "incoming_ctx", incomingID)
 	}
 	return corID
 }
 
 // correlationID will find or create a requestID for this http request.
-func correlationID(req *http.Request) string {
-	// 8 chars will have enough entropy
+func correlationID(req *http.Request, o *options) string {
+	// 12 chars will have enough entropy
 	// to correlate requests,
 	// without the large footprint in the logs
 	corID := ID(req.Context())
 	if corID == "" {
-		incomingID := req.Header.Get(header.XCorrelationID)
+		incomingID := req.Header.Get(o.header)
+		if incomingID == "" {
+			incomingID = req.Header.Get(header.XCorrelationID)
+		}
 		if incomingID == "" {
 			incomingID = req.Header.Get("X-Request-ID")
 		}
+		if incomingID == "" && o.traceParent {
+			incomingID, _ = parseTraceParent(req.Header.Get(header.TraceParent))
+		}
 
 		if incomingID != "" {
-			corID = slices.StringUpto(incomingID, IDSize)
-		} else {
-			corID = certutil.RandomString(IDSize)
+			corID = fromIncoming(incomingID, o)
+		}
+		if corID == "" {
+			corID = o.gen()
 		}
 
 		path := ""
@@ -138,11 +186,53 @@ func correlationID(req *http.Request) string {
 		if strings.Contains(req.Header.Get(header.Accept), "json") {
 			l = xlog.TRACE
 		}
-		logger.KV(l, "ctx", corID, "incoming_ctx", incomingID, "path", path)
+		logger.KV(l, LogKey, corID, "incoming_ctx", incomingID, "path", path)
 	}
 	return corID
 }
 
+// fromIncoming returns Correlation ID from the incoming ID,
+// or empty string if a new ID must be generated.
+// The disallowed characters are dropped to prevent log injection
+func fromIncoming(incomingID string, o *options) string {
+	if o.traceID {
+		if id := strings.ToLower(incomingID); isTraceID(id) {
+			return id
+		}
+	}
+	id := sanitizeID(incomingID)
+	if id != incomingID && o.regenerateInvalid {
+		return ""
+	}
+	return slices.StringUpto(boundSegments(id, o.maxSegments), o.size)
+}
+
+// sanitizeID returns the ID with only alphanumeric, `-` and `_` characters
+func sanitizeID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, id)
+}
+
+// boundSegments returns the ID with at most max underscore-joined segments,
+// the first segment is the root and always preserved
+func boundSegments(id string, max int) string {
+	if max <= 0 {
+		return id
+	}
+	segments := strings.Split(id, "_")
+	if len(segments) <= max {
+		return id
+	}
+	if max == 1 {
+		return segments[0]
+	}
+	return segments[0] + "_" + strings.Join(segments[len(segments)-max+1:], "_")
+}
+
 // Value returns correlation RequestContext from the context
 func Value(ctx context.Context) *RequestContext {
 	v := ctx.Value(keyContext)
@@ -172,11 +262,25 @@ func WithID(ctx context.Context) context.Context {
 			ID: certutil.RandomString(IDSize),
 		}
 		ctx = context.WithValue(ctx, keyContext, rctx)
-		ctx = xlog.ContextWithKV(ctx, "ctx", rctx.ID)
+		ctx = xlog.ContextWithKV(ctx, LogKey, rctx.ID)
 	}
 	return ctx
 }
 
+// WithTestID returns context with the provided Correlation ID,
+// replacing the original if present.
+// It allows to seed a deterministic Correlation ID in tests
+func WithTestID(ctx context.Context, id string) context.Context {
+	rctx := &RequestContext{
+		ID: id,
+	}
+	if v := Value(ctx); v != nil {
+		rctx.ClientIP = v.ClientIP
+	}
+	ctx = context.WithValue(ctx, keyContext, rctx)
+	return xlog.ContextWithKV(ctx, LogKey, rctx.ID)
+}
+
 // WithMetaFromContext returns context with Correlation ID
 // for the outgoing gRPC call
 func WithMetaFromContext(ctx context.Context) context.Context {
@@ -186,7 +290,7 @@ func WithMetaFromContext(ctx context.Context) context.Context {
 			ID: certutil.RandomString(IDSize),
 		}
 		ctx = context.WithValue(ctx, keyContext, rctx)
-		ctx = xlog.ContextWithKV(ctx, "ctx", rctx.ID)
+		ctx = xlog.ContextWithKV(ctx, LogKey, rctx.ID)
 		v = rctx
 	}
 	cid := v.(*RequestContext).ID
@@ -196,12 +300,14 @@ func WithMetaFromContext(ctx context.Context) context.Context {
 // WithMetaFromRequest returns context with Correlation ID
 // for the outgoing gRPC call
 func WithMetaFromRequest(req *http.Request) context.Context {
-	cid := correlationID(req)
+	o := newOptions(nil)
+	cid := correlationID(req, o)
 	rctx := &RequestContext{
-		ID: cid,
+		ID:       cid,
+		ClientIP: clientIP(req, o),
 	}
 	ctx := context.WithValue(req.Context(), keyContext, rctx)
-	ctx = xlog.ContextWithKV(ctx, "ctx", rctx.ID)
+	ctx = xlog.ContextWithKV(ctx, LogKey, rctx.ID)
 	return metadata.AppendToOutgoingContext(ctx, CorrelationIDgRPCHeaderName, cid)
 }
 
@@ -215,6 +321,66 @@ func NewFromContext(ctx context.Context) context.Context {
 		ID: cid,
 	}
 	ctx = context.WithValue(context.Background(), keyContext, rctx)
-	ctx = xlog.ContextWithKV(ctx, "ctx", rctx.ID)
+	ctx = xlog.ContextWithKV(ctx, LogKey, rctx.ID)
 	return metadata.AppendToOutgoingContext(ctx, CorrelationIDgRPCHeaderName, cid)
 }
+
+// ClientIP returns the client address captured from HTTP request,
+// or empty string if the context does not have it
+func ClientIP(ctx context.Context) string {
+	v := Value(ctx)
+	if v != nil {
+		return v.ClientIP
+	}
+	return ""
+}
+
+// clientIP returns the client address from HTTP request,
+// the proxy headers are used only when trusted
+func clientIP(req *http.Request, o *options) string {
+	remote := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	if !o.trustedProxy ||
+		(len(o.trustedNets) > 0 && !isTrustedProxy(o.trustedNets, net.ParseIP(remote))) {
+		return remote
+	}
+
+	if xff := strings.Join(req.Header.Values(header.XForwardedFor), ","); xff != "" {
+		// the addresses on the left are set by the client,
+		// and may be forged
+		var client string
+		hops := strings.Split(xff, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				break
+			}
+			client = ip.String()
+			if !isTrustedProxy(o.trustedNets, ip) {
+				break
+			}
+		}
+		if client != "" {
+			return client
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(req.Header.Get(header.XRealIP))); ip != nil {
+		return ip.String()
+	}
+	return remote
+}
+
+// isTrustedProxy returns true if the IP is in one of the networks
+func isTrustedProxy(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package correlation
+
+import (
+	"context"
+
+	"github.com/effective-security/xlog"
+)
+
+// LogKey specifies the key of Correlation ID in the log entries
+const LogKey = "ctx"
+
+// LogKV returns the log entries with Correlation ID from the context,
+// or nil if the context does not have Correlation ID.
+//
+// Note that the handler and interceptors already add Correlation ID
+// to the context, so it's included by logger.ContextKV
+func LogKV(ctx context.Context) []interface{} {
+	cid := ID(ctx)
+	if cid == "" {
+		return nil
+	}
+	return []interface{}{LogKey, cid}
+}
+
+// Logger returns the logger with Correlation ID from the context,
+// that can be used with logger.KV calls
+func Logger(ctx context.Context, logger xlog.KeyValueLogger) xlog.KeyValueLogger {
+	kv := LogKV(ctx)
+	if kv == nil {
+		return logger
+	}
+	return logger.WithValues(kv...)
+}
@@ -0,0 +1,182 @@
+package correlation
+
+import (
+	"net"
+	"strings"
+
+	"github.com/effective-security/porto/xhttp/header"
+	"github.com/effective-security/xlog"
+	"github.com/effective-security/xpki/certutil"
+)
+
+// Option is an option that can be passed to NewHandler() and NewAuthUnaryInterceptor().
+// Option configures how the Correlation ID is extracted and generated
+type Option interface {
+	apply(*options)
+}
+
+// WithHeaderName option to specify HTTP header name for Correlation ID,
+// by default it's `X-Correlation-ID`
+func WithHeaderName(name string) Option {
+	return newFuncOption(func(o *options) {
+		o.header = name
+	})
+}
+
+// WithIDSize option to specify the size of generated Correlation ID,
+// the incoming IDs are truncated to this size.
+// By default it's IDSize
+func WithIDSize(size int) Option {
+	return newFuncOption(func(o *options) {
+		o.size = size
+	})
+}
+
+// WithIDGenerator option to provide a generator of Correlation ID,
+// that is used when the request does not have the incoming ID.
+// By default a random string of IDSize is generated
+func WithIDGenerator(gen func() string) Option {
+	return newFuncOption(func(o *options) {
+		o.gen = gen
+	})
+}
+
+// WithTraceParent option to derive Correlation ID from the trace-id
+// of W3C Trace Context `traceparent` header, when the Correlation ID header is absent.
+// The `traceparent` header is also set in the response
+func WithTraceParent() Option {
+	return newFuncOption(func(o *options) {
+		o.traceParent = true
+	})
+}
+
+// WithTrustedProxy option to capture the client IP from
+// `X-Forwarded-For` or `X-Real-IP` headers, set by a trusted proxy.
+// The `X-Forwarded-For` is walked from the right, the addresses of
+// the trusted proxies are skipped, and the first untrusted address is the client.
+// The proxies are specified by IP or CIDR, for example `10.0.0.0/8`,
+// the invalid values are ignored. If the proxies are specified,
+// the headers are used only when the remote address is one of them,
+// otherwise only the remote address is trusted, and the last address
+// of `X-Forwarded-For` is the client.
+// By default the remote address of the connection is used
+func WithTrustedProxy(proxies ...string) Option {
+	return newFuncOption(func(o *options) {
+		o.trustedProxy = true
+		for _, p := range proxies {
+			n, err := parseNetwork(p)
+			if err != nil {
+				logger.KV(xlog.ERROR, "reason", "invalid_proxy", "proxy", p, "err", err.Error())
+				continue
+			}
+			o.trustedNets = append(o.trustedNets, n)
+		}
+	})
+}
+
+// parseNetwork returns the network for IP or CIDR
+func parseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: s}
+	}
+	bits := 8 * net.IPv4len
+	if ip.To4() == nil {
+		bits = 8 * net.IPv6len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// WithMaxSegments option to bound the number of underscore-joined segments
+// of the incoming Correlation ID, chained by upstream services.
+// The oldest segments are trimmed, while the original root is preserved,
+// for example `root_a_b_c` is bounded to `root_b_c` with max of 3.
+// The segments are bounded before the ID is truncated to the ID size.
+// By default the number of segments is not limited
+func WithMaxSegments(max int) Option {
+	return newFuncOption(func(o *options) {
+		o.maxSegments = max
+	})
+}
+
+// WithRegenerateInvalidID option to generate a new Correlation ID,
+// if the incoming ID has characters other than alphanumeric, `-` and `_`.
+// By default the disallowed characters are dropped from the incoming ID
+func WithRegenerateInvalidID() Option {
+	return newFuncOption(func(o *options) {
+		o.regenerateInvalid = true
+	})
+}
+
+// WithTraceID option to generate Correlation ID compatible with
+// OpenTelemetry trace-id of TraceIDSize hex characters,
+// the incoming IDs in the trace-id format are used as is,
+// the other incoming IDs are truncated to TraceIDSize.
+// When combined with WithTraceParent, the generated `traceparent`
+// in the response has the Correlation ID as trace-id.
+// This option overrides WithIDSize
+func WithTraceID() Option {
+	return newFuncOption(func(o *options) {
+		o.traceID = true
+	})
+}
+
+type options struct {
+	header       string
+	size         int
+	maxSegments  int
+	gen          func() string
+	traceParent  bool
+	traceID      bool
+	trustedProxy bool
+	trustedNets  []*net.IPNet
+
+	regenerateInvalid bool
+}
+
+func newOptions(ops []Option) *options {
+	o := &options{
+		header: header.XCorrelationID,
+		size:   IDSize,
+	}
+	for _, op := range ops {
+		op.apply(o)
+	}
+	if o.header == "" {
+		o.header = header.XCorrelationID
+	}
+	if o.size <= 0 {
+		o.size = IDSize
+	}
+	if o.traceID {
+		o.size = TraceIDSize
+		if o.gen == nil {
+			o.gen = newTraceID
+		}
+	}
+	if o.gen == nil {
+		size := o.size
+		o.gen = func() string {
+			return certutil.RandomString(size)
+		}
+	}
+	return o
+}
+
+type funcOption struct {
+	f func(*options)
+}
+
+func (fo *funcOption) apply(o *options) {
+	fo.f(o)
+}
+
+func newFuncOption(f func(*options)) *funcOption {
+	return &funcOption{
+		f: f,
+	}
+}
@@ -0,0 +1,34 @@
+package correlation
+
+import (
+	"net/http"
+
+	"github.com/effective-security/porto/xhttp/header"
+)
+
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+// NewRoundTripper returns http.RoundTripper that sets
+// Correlation ID from the request context in the outgoing request header.
+// If next is nil, then http.DefaultTransport is used
+func NewRoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{
+		next: next,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	cid := ID(req.Context())
+	if cid != "" && req.Header.Get(header.XCorrelationID) == "" {
+		// RoundTripper must not modify the original request
+		req = req.Clone(req.Context())
+		req.Header.Set(header.XCorrelationID, cid)
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,61 @@
+package correlation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// parseTraceParent returns trace-id from W3C Trace Context `traceparent` header,
+// in the format of `version-traceid-parentid-flags`
+func parseTraceParent(tp string) (traceID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(tp), "-")
+	if len(parts) < 4 ||
+		!isHex(parts[0], 2) || parts[0] == "ff" ||
+		!isTraceID(parts[1]) ||
+		!isHex(parts[2], 16) || parts[2] == strings.Repeat("0", 16) ||
+		!isHex(parts[3], 2) {
+		return "", false
+	}
+	// version 00 must have exactly 4 parts
+	if parts[0] == "00" && len(parts) != 4 {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// newTraceParent returns a new `traceparent` value with random parent-id,
+// and the provided trace-id, or random one if the trace-id is not valid
+func newTraceParent(traceID string) string {
+	if !isTraceID(traceID) {
+		traceID = newTraceID()
+	}
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return "00-" + traceID + "-" + hex.EncodeToString(b) + "-00"
+}
+
+// newTraceID returns a random W3C Trace Context trace-id,
+// compatible with OpenTelemetry
+func newTraceID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isTraceID returns true if the ID is a valid W3C Trace Context trace-id
+func isTraceID(id string) bool {
+	return isHex(id, TraceIDSize) && id != strings.Repeat("0", TraceIDSize)
+}
+
+func isHex(s string, size int) bool {
+	if len(s) != size {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -46,16 +46,24 @@ const (
 	ReplayNonce = "Replay-Nonce"
 	// TextPlain is HTTP header value for "application/json"
 	TextPlain = "text/plain"
+	// TraceParent is W3C Trace Context header for "traceparent"
+	TraceParent = "traceparent"
 	// UserAgent is HTTP header value for "User-Agent"
 	UserAgent = "User-Agent"
 	// XHostname contains the name of the HTTP header to indicate which host requested the signature
 	XHostname = "X-HostName"
+	// XAPIKey is HTTP header for "X-API-Key"
+	XAPIKey = "X-API-Key"
 	// XCorrelationID is HTTP header for "X-Correlation-ID"
 	XCorrelationID = "X-Correlation-ID"
 	// XDeviceID is HTTP header for "X-Device-ID"
 	XDeviceID = "X-Device-ID"
 	// XFilename contains the name of the artifact to sign
 	XFilename = "X-Filename"
+	// XForwardedFor contains the client and proxies addresses
+	XForwardedFor = "X-Forwarded-For"
 	// XForwardedProto contains the protocol
 	XForwardedProto = "X-Forwarded-Proto"
+	// XRealIP contains the client address
+	XRealIP = "X-Real-IP"
 )
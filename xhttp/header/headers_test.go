@@ -22,10 +22,14 @@ func Test_Headers(t *testing.T) {
 	assert.Equal(t, "If-Match", header.IfMatch)
 	assert.Equal(t, "Replay-Nonce", header.ReplayNonce)
 	assert.Equal(t, "text/plain", header.TextPlain)
+	assert.Equal(t, "traceparent", header.TraceParent)
 	assert.Equal(t, "User-Agent", header.UserAgent)
 	assert.Equal(t, "X-HostName", header.XHostname)
+	assert.Equal(t, "X-API-Key", header.XAPIKey)
 	assert.Equal(t, "X-Correlation-ID", header.XCorrelationID)
 	assert.Equal(t, "X-Device-ID", header.XDeviceID)
 	assert.Equal(t, "X-Filename", header.XFilename)
+	assert.Equal(t, "X-Forwarded-For", header.XForwardedFor)
 	assert.Equal(t, "X-Forwarded-Proto", header.XForwardedProto)
+	assert.Equal(t, "X-Real-IP", header.XRealIP)
 }
@@ -3,6 +3,7 @@ package identity
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/effective-security/porto/x/netutil"
 	"github.com/effective-security/porto/x/slices"
@@ -20,11 +21,75 @@ type Identity interface {
 	Role() string
 	Subject() string
 	Tenant() string
+	// Claims returns a copy of the verified claims of identity,
+	// for TLS identities the claims contain the certificate subject fields
 	Claims() jwt.MapClaims
 	AccessToken() string
 	TokenType() string
 }
 
+// ScopedIdentity is implemented by Identity that carries OAuth scopes
+type ScopedIdentity interface {
+	Identity
+	// Scopes returns the list of scopes granted to identity
+	Scopes() []string
+	// HasScope returns true if the scope is granted to identity
+	HasScope(scope string) bool
+}
+
+// HasScope returns true if the identity implements ScopedIdentity,
+// and the scope is granted to identity
+func HasScope(id Identity, scope string) bool {
+	if sid, ok := id.(ScopedIdentity); ok {
+		return sid.HasScope(scope)
+	}
+	return false
+}
+
+// MultiRoleIdentity is implemented by Identity that carries
+// multiple roles, Role returns the primary role
+type MultiRoleIdentity interface {
+	Identity
+	// Roles returns the list of roles of identity,
+	// the first is the primary role
+	Roles() []string
+}
+
+// Roles returns the list of roles of identity,
+// if the identity does not implement MultiRoleIdentity,
+// then only the role is returned
+func Roles(id Identity) []string {
+	if mid, ok := id.(MultiRoleIdentity); ok {
+		return mid.Roles()
+	}
+	if role := id.Role(); role != "" {
+		return []string{role}
+	}
+	return nil
+}
+
+// HasRole returns true if the role is one of identity's roles
+func HasRole(id Identity, role string) bool {
+	for _, r := range Roles(id) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiringIdentity is implemented by Identity that carries
+// the token timestamps
+type ExpiringIdentity interface {
+	Identity
+	// ExpiresAt returns the expiration time of the token,
+	// or zero time if not available
+	ExpiresAt() time.Time
+	// IssuedAt returns the time when the token was issued,
+	// or zero time if not available
+	IssuedAt() time.Time
+}
+
 // ProviderFromRequest returns Identity from supplied HTTP request
 type ProviderFromRequest func(*http.Request) (Identity, error)
 
@@ -33,13 +98,34 @@ type ProviderFromContext func(ctx context.Context, uri string) (Identity, error)
 
 // NewIdentity returns a new Identity instance with the indicated role
 func NewIdentity(role, subject, tenant string, claims map[string]interface{}, accessToken, tokenType string) Identity {
+	return NewIdentityWithScopes(role, subject, tenant, claims, accessToken, tokenType, nil)
+}
+
+// NewIdentityWithScopes returns a new Identity instance with the indicated role and scopes
+func NewIdentityWithScopes(role, subject, tenant string, claims map[string]interface{}, accessToken, tokenType string, scopes []string) Identity {
+	var roles []string
+	if role != "" {
+		roles = []string{role}
+	}
+	return NewIdentityWithRoles(roles, subject, tenant, claims, accessToken, tokenType, scopes)
+}
+
+// NewIdentityWithRoles returns a new Identity instance with the indicated roles and scopes,
+// the first role is the primary role
+func NewIdentityWithRoles(roles []string, subject, tenant string, claims map[string]interface{}, accessToken, tokenType string, scopes []string) Identity {
+	var role string
+	if len(roles) > 0 {
+		role = roles[0]
+	}
 	id := identity{
 		role:        role,
+		roles:       roles,
 		subject:     subject,
 		tenant:      tenant,
 		claims:      jwt.MapClaims{},
 		accessToken: accessToken,
 		tokenType:   tokenType,
+		scopes:      scopes,
 	}
 	if claims != nil {
 		_ = id.claims.Add(claims)
@@ -56,11 +142,14 @@ type identity struct {
 	tenant string
 	// role of identity
 	role string
+	// roles of identity, the first is the primary role
+	roles []string
 	// extra user info, specific to the application
 	claims jwt.MapClaims
 
 	accessToken string
 	tokenType   string
+	scopes      []string
 }
 
 // Subject returns the client's subject.
@@ -80,6 +169,12 @@ func (c identity) Role() string {
 	return c.role
 }
 
+// Roles returns the list of roles of identity,
+// the first is the primary role
+func (c identity) Roles() []string {
+	return append([]string(nil), c.roles...)
+}
+
 // AccessToken returns AccessToken for identity
 func (c identity) AccessToken() string {
 	return c.accessToken
@@ -90,6 +185,33 @@ func (c identity) TokenType() string {
 	return c.tokenType
 }
 
+// Scopes returns the list of scopes granted to identity
+func (c identity) Scopes() []string {
+	return append([]string(nil), c.scopes...)
+}
+
+// HasScope returns true if the scope is granted to identity
+func (c identity) HasScope(scope string) bool {
+	for _, s := range c.scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ExpiresAt returns the expiration time from `exp` claim,
+// or zero time if not available
+func (c identity) ExpiresAt() time.Time {
+	return c.claims.TimeVal("exp")
+}
+
+// IssuedAt returns the issued time from `iat` claim,
+// or zero time if not available
+func (c identity) IssuedAt() time.Time {
+	return c.claims.TimeVal("iat")
+}
+
 // Claims returns application specific user info
 func (c identity) Claims() jwt.MapClaims {
 	res := jwt.MapClaims{}
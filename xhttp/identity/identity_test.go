@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/effective-security/porto/xhttp/correlation"
 	"github.com/effective-security/xpki/jwt"
@@ -74,6 +75,58 @@ func Test_NewIdentityWithClaims(t *testing.T) {
 	assert.Equal(t, "org", claims["tenant"])
 }
 
+func Test_NewIdentityWithScopes(t *testing.T) {
+	id := NewIdentity("role1", "name1", "", nil, "", "")
+	assert.False(t, HasScope(id, "read"))
+
+	id = NewIdentityWithScopes("role1", "name1", "", nil, "", "", []string{"read", "write"})
+	assert.True(t, HasScope(id, "read"))
+	assert.True(t, HasScope(id, "write"))
+	assert.False(t, HasScope(id, "admin"))
+	assert.Equal(t, []string{"read", "write"}, id.(ScopedIdentity).Scopes())
+
+	// the scopes are not modified by the caller
+	scopes := id.(ScopedIdentity).Scopes()
+	scopes[0] = "admin"
+	assert.Equal(t, []string{"read", "write"}, id.(ScopedIdentity).Scopes())
+	assert.False(t, HasScope(id, "admin"))
+}
+
+func Test_ExpiringIdentity(t *testing.T) {
+	id := NewIdentity("role1", "name1", "", nil, "", "").(ExpiringIdentity)
+	assert.True(t, id.ExpiresAt().IsZero())
+	assert.True(t, id.IssuedAt().IsZero())
+
+	iat := time.Now().Add(-time.Minute).Unix()
+	exp := time.Now().Add(time.Hour).Unix()
+	id = NewIdentity("role1", "name1", "", jwt.MapClaims{
+		"iat": iat,
+		"exp": float64(exp),
+	}, "", "").(ExpiringIdentity)
+	assert.Equal(t, exp, id.ExpiresAt().Unix())
+	assert.Equal(t, iat, id.IssuedAt().Unix())
+}
+
+func Test_MultiRoleIdentity(t *testing.T) {
+	id := NewIdentityWithRoles([]string{"billing-admin", "support"}, "name1", "", nil, "", "", nil)
+	assert.Equal(t, "billing-admin", id.Role())
+	assert.Equal(t, []string{"billing-admin", "support"}, Roles(id))
+	assert.True(t, HasRole(id, "support"))
+	assert.False(t, HasRole(id, "admin"))
+
+	id = NewIdentity("role1", "name1", "", nil, "", "")
+	assert.Equal(t, []string{"role1"}, id.(MultiRoleIdentity).Roles())
+
+	id = NewIdentityWithRoles(nil, "name1", "", nil, "", "", nil)
+	assert.Empty(t, id.Role())
+	assert.Empty(t, Roles(id))
+
+	// Identity without MultiRoleIdentity
+	single := struct{ Identity }{NewIdentity("role1", "name1", "", nil, "", "")}
+	assert.Equal(t, []string{"role1"}, Roles(single))
+	assert.True(t, HasRole(single, "role1"))
+}
+
 func Test_WithTestIdentityServeHTTP(t *testing.T) {
 	d := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		caller := FromRequest(r)